@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prestonp/gbc/pkg/disasm"
+	"github.com/prestonp/gbc/pkg/gb"
+)
+
+// runDisasm implements `gbc disasm`, printing the instructions in an address
+// range of a rom
+func runDisasm(args []string) {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	file := fs.String("f", "", "rom file")
+	bank := fs.Int("bank", 1, "rom bank mapped into 0x4000-0x7FFF")
+	from := fs.Uint("from", 0x0100, "start address")
+	to := fs.Uint("to", 0x0150, "end address (exclusive)")
+	sym := fs.String("sym", "", "symbol file, defaults to the rom path with a .sym extension if present")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatal("missing filename")
+	}
+	if *from > 0xFFFF || *to > 0x10000 || *from > *to {
+		log.Fatalf("invalid address range 0x%04X-0x%04X", *from, *to)
+	}
+
+	rom, err := gb.ReadRom(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	symPath := *sym
+	if symPath == "" {
+		symPath = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".sym"
+		if _, err := os.Stat(symPath); err != nil {
+			symPath = ""
+		}
+	}
+
+	opts := []disasm.Option{disasm.WithBank(*bank)}
+	if symPath != "" {
+		f, err := os.Open(symPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		syms, err := disasm.ParseSymbols(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, disasm.WithSymbols(syms))
+	}
+
+	d := disasm.New(disasm.ROM(rom, *bank), opts...)
+	for _, i := range d.Range(uint16(*from), int(*to)) {
+		if label, ok := d.Label(i.Addr); ok {
+			fmt.Printf("%s:\n", label)
+		}
+		fmt.Println(i)
+	}
+}
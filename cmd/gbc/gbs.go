@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prestonp/gbc/pkg/audio"
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gbs"
+	"github.com/prestonp/gbc/pkg/wav"
+)
+
+// runPlayGBS implements `gbc play-gbs`, playing a GBS rip live or exporting a
+// track to a wav file
+func runPlayGBS(args []string) {
+	fs := flag.NewFlagSet("play-gbs", flag.ExitOnError)
+	track := fs.Int("track", 0, "track to play, 1 based, defaults to the file's first song")
+	length := fs.Duration("duration", 2*time.Minute+30*time.Second, "how long each track plays before fading out")
+	fade := fs.Duration("fade", 5*time.Second, "fade out length")
+	out := fs.String("wav", "", "export the track to a wav file instead of playing it")
+	volume := fs.Float64("volume", 1, "audio volume from 0 to 1")
+	files, _ := parseArgs(fs, args)
+
+	if len(files) != 1 {
+		log.Fatal("usage: gbc play-gbs [flags] file.gbs")
+	}
+	f, err := gbs.ReadFile(files[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *track == 0 {
+		*track = f.First
+	}
+	fmt.Printf("%s - %s (%s), %d tracks\n", f.Title, f.Author, f.Copyright, f.Songs)
+
+	sound := apu.New()
+	p := gbs.NewPlayer(f, sound)
+
+	if *out != "" {
+		w, err := wav.Create(*out, sound.SampleRate())
+		if err != nil {
+			log.Fatal(err)
+		}
+		sound.SetSink(gbs.NewFade(w, sound.SampleRate(), *length, *fade))
+		err = p.Start(*track)
+		if err == nil {
+			err = p.Run(gbs.Cycles(*length + *fade))
+		}
+		sound.Flush()
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	player := audio.NewPlayer(apu.DefaultSampleRate / 10)
+	player.SetVolume(*volume)
+	dev, err := audio.Open(player, sound.SampleRate())
+	if err != nil {
+		log.Fatalf("audio: %v, use --wav to export instead", err)
+	}
+	defer dev.Close()
+
+	// tracks are switched by typing n, p or a track number
+	commands := make(chan string)
+	go func() {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			commands <- strings.TrimSpace(s.Text())
+		}
+	}()
+	fmt.Println("enter n for the next track, p for the previous one or a track number")
+
+	for t := *track; t <= f.Songs; {
+		if t, err = playTrack(p, player, sound, t, *length, *fade, commands); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// parseArgs parses flags that come before or after the positional arguments,
+// e.g. `play-gbs file.gbs --track 2`, and returns the positional ones. The
+// flag package stops at the first non-flag argument, so parsing restarts after
+// each one.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// playTrack plays until the track has faded out or another track is picked
+// and returns the track to play next
+func playTrack(p *gbs.Player, player *audio.Player, sound *apu.APU, track int, length, fade time.Duration, commands <-chan string) (int, error) {
+	if err := p.Start(track); err != nil {
+		return 0, err
+	}
+	fmt.Printf("track %d\n", track)
+	sound.SetSink(gbs.NewFade(player.Sink(), sound.SampleRate(), length, fade))
+
+	// run a frame's worth at a time and let the wall clock catch up, the
+	// audio player's rate control absorbs the jitter
+	total := gbs.Cycles(length + fade)
+	start := time.Now()
+	for played := 0; played < total; played += gb.CyclesPerFrame {
+		select {
+		case cmd := <-commands:
+			next := track
+			switch cmd {
+			case "n":
+				next++
+			case "p":
+				next--
+			default:
+				n, err := strconv.Atoi(cmd)
+				if err != nil {
+					fmt.Printf("unknown command %q\n", cmd)
+					continue
+				}
+				next = n
+			}
+			if next < 1 || next > p.Songs() {
+				fmt.Printf("no track %d\n", next)
+				continue
+			}
+			return next, nil
+		default:
+		}
+
+		if err := p.Run(gb.CyclesPerFrame); err != nil {
+			return 0, err
+		}
+		elapsed := time.Duration(float64(played+gb.CyclesPerFrame) / gb.ClockSpeed * float64(time.Second))
+		time.Sleep(time.Until(start.Add(elapsed)))
+	}
+	return track + 1, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"--track", "2", "song.gbs"},
+		{"song.gbs", "--track", "2"},
+		{"--wav", "out.wav", "song.gbs", "--track", "2"},
+	} {
+		fs := flag.NewFlagSet("play-gbs", flag.ContinueOnError)
+		track := fs.Int("track", 0, "")
+		fs.String("wav", "", "")
+
+		files, err := parseArgs(fs, args)
+		require.NoError(t, err, "%q", args)
+		require.Equal(t, []string{"song.gbs"}, files, "%q", args)
+		require.Equal(t, 2, *track, "%q", args)
+	}
+
+	fs := flag.NewFlagSet("play-gbs", flag.ContinueOnError)
+	files, err := parseArgs(fs, []string{"a.gbs", "b.gbs"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.gbs", "b.gbs"}, files)
+}
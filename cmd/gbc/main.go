@@ -1,40 +1,415 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"image"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	_ "embed"
 
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/prestonp/gbc/pkg/audio"
+	"github.com/prestonp/gbc/pkg/debugger"
 	"github.com/prestonp/gbc/pkg/gb"
 	"github.com/prestonp/gbc/pkg/gb/apu"
 	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/prestonp/gbc/pkg/gdb"
+	"github.com/prestonp/gbc/pkg/link"
+	"github.com/prestonp/gbc/pkg/movie"
+	"github.com/prestonp/gbc/pkg/printer"
+	"github.com/prestonp/gbc/pkg/rewind"
+	"github.com/prestonp/gbc/pkg/wav"
 )
 
 //go:embed boot.gb
 var boot []byte
 
 var (
-	debug = flag.Bool("debug", false, "debug mode")
-	file  = flag.String("f", "", "rom file")
+	debug  = flag.Bool("debug", false, "debug mode")
+	file   = flag.String("f", "", "rom file")
+	trace  = flag.String("trace", "", "write a gameboy-doctor style instruction trace to a file")
+	repl   = flag.Bool("debug-repl", false, "start paused in an interactive debugger on stdin")
+	gdbOn  = flag.String("gdb", "", "start paused and wait for a gdb remote protocol client on this address, e.g. :2345")
+	state  = flag.String("state", "", "save state file for the F5 (save) and F9 (load) hotkeys, defaults to the rom path with a .state extension")
+	load   = flag.String("load-state", "", "restore a save state before starting")
+	rwnd   = flag.Int("rewind-seconds", 30, "seconds of history kept for rewinding with backspace, 0 disables rewind")
+	recMv  = flag.String("record-movie", "", "record joypad input to a movie file, starting from the --load-state state if given")
+	playM  = flag.String("play-movie", "", "play back joypad input from a movie file")
+	unmap  = flag.String("unmapped", "openbus", "handling of accesses to unmapped addresses, openbus reads 0xFF with a warning and stop halts emulation")
+	speed  = flag.Float64("speed", 1, "emulation speed relative to the hardware, e.g. 2, 4 or 0.25, 0 runs unthrottled")
+	volume = flag.Float64("volume", 1, "audio volume from 0 to 1, minus and equals adjust it and M mutes")
+	rate   = flag.Int("sample-rate", apu.DefaultSampleRate, "audio output sample rate in Hz, for the audio device and recordings")
+	linkTo = flag.String("link", "", "device on the link cable, printer saves printouts as PNGs next to the rom")
+	linkL  = flag.String("link-listen", "", "wait for another emulator to connect a link cable on this address, e.g. :7777")
+	linkC  = flag.String("link-connect", "", "connect a link cable to another emulator listening on this address, e.g. localhost:7777")
+	colorC = flag.Bool("color-correction", false, "show game boy color games with the colors of the original LCD instead of raw RGB")
+	recAu  = flag.String("record-audio", "", "record the audio output to a wav file, the F8 hotkey toggles recording and numbers recordings after the first, defaults to the rom path with a .wav extension")
 )
 
+// speedKeys select a fixed speed, holding tab fast-forwards unthrottled
+var speedKeys = map[pixelgl.Button]float64{
+	pixelgl.Key1: 1,
+	pixelgl.Key2: 2,
+	pixelgl.Key3: 4,
+	pixelgl.Key4: 0.25,
+}
+
+// channelKeys toggle the four sound channels
+var channelKeys = map[pixelgl.Button]int{
+	pixelgl.KeyF1: 1,
+	pixelgl.KeyF2: 2,
+	pixelgl.KeyF3: 3,
+	pixelgl.KeyF4: 4,
+}
+
+// keys maps the keyboard to the joypad
+var keys = map[pixelgl.Button]gb.Buttons{
+	pixelgl.KeyRight:      gb.ButtonRight,
+	pixelgl.KeyLeft:       gb.ButtonLeft,
+	pixelgl.KeyUp:         gb.ButtonUp,
+	pixelgl.KeyDown:       gb.ButtonDown,
+	pixelgl.KeyZ:          gb.ButtonA,
+	pixelgl.KeyX:          gb.ButtonB,
+	pixelgl.KeyRightShift: gb.ButtonSelect,
+	pixelgl.KeyEnter:      gb.ButtonStart,
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		runDisasm(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "play-gbs" {
+		runPlayGBS(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
 	if *file == "" {
 		log.Fatal("missing filename")
 	}
+	if *rate < 8000 || *rate > 192000 {
+		log.Fatalf("invalid sample rate %d, expected 8000 to 192000 Hz", *rate)
+	}
 	rom, err := gb.ReadRom(*file)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	gpu := gpu.New(gpu.WithDebugger(*debug))
-	apu := apu.New()
-	mmu := gb.NewMMU(boot, rom, gpu, apu)
-	cpu := gb.NewCPU(mmu, gpu, *debug)
+	statePath := *state
+	if statePath == "" {
+		statePath = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".state"
+	}
+	audioPath := *recAu
+	if audioPath == "" {
+		audioPath = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".wav"
+	}
+
+	// the cpu is created after the gpu, hotkeys run once it's assigned
+	var cpu *gb.CPU
+	var rewinder *rewind.Rewinder
+	var input *movie.Input
+	var sound *apu.APU
+	var recorder *wav.Writer // only touched on the emulation goroutine
+	var takes int            // audio recordings made so far
+	var live apu.AudioSink = audio.Null{}
+	player := audio.NewPlayer(*rate / 10)
+	player.SetVolume(*volume)
+	selectedSpeed := *speed
+	gpuOpts := []gpu.Option{
+		gpu.WithDebugger(*debug),
+		gpu.WithColorCorrection(*colorC),
+		gpu.WithOverlay(func() image.Image {
+			return sound.Oscilloscope()
+		}),
+		gpu.WithHoldKey(pixelgl.KeyTab, func(held bool) {
+			if held {
+				cpu.SetSpeed(0)
+			} else {
+				cpu.SetSpeed(selectedSpeed)
+			}
+		}),
+		gpu.WithHoldKey(pixelgl.KeyBackspace, func(held bool) {
+			if rewinder != nil {
+				rewinder.SetRewinding(held)
+			}
+		}),
+		gpu.WithHotkey(pixelgl.KeyF5, func() {
+			cpu.Do(func() {
+				if err := cpu.SaveStateFile(statePath); err != nil {
+					log.Printf("save state: %v", err)
+					return
+				}
+				log.Printf("saved state to %s", statePath)
+			})
+		}),
+		gpu.WithHotkey(pixelgl.KeyM, func() {
+			if player.ToggleMute() {
+				log.Print("audio muted")
+			} else {
+				log.Print("audio unmuted")
+			}
+		}),
+		gpu.WithHotkey(pixelgl.KeyMinus, func() {
+			player.SetVolume(player.Volume() - 0.1)
+			log.Printf("volume %.0f%%", player.Volume()*100)
+		}),
+		gpu.WithHotkey(pixelgl.KeyEqual, func() {
+			player.SetVolume(player.Volume() + 0.1)
+			log.Printf("volume %.0f%%", player.Volume()*100)
+		}),
+		gpu.WithHotkey(pixelgl.KeyF8, func() {
+			cpu.Do(func() {
+				if recorder != nil {
+					sound.SetSink(live)
+					if err := recorder.Close(); err != nil {
+						log.Printf("record audio: %v", err)
+					}
+					recorder = nil
+					log.Print("stopped recording audio")
+					return
+				}
+				takes++
+				path := takePath(audioPath, takes)
+				w, err := wav.Create(path, sound.SampleRate())
+				if err != nil {
+					log.Printf("record audio: %v", err)
+					return
+				}
+				recorder = w
+				sound.SetSink(apu.Tee(live, recorder))
+				log.Printf("recording audio to %s", path)
+			})
+		}),
+		gpu.WithHotkey(pixelgl.KeyF9, func() {
+			cpu.Do(func() {
+				if input.Recording() || input.Playing() {
+					// the movie's input would land on different frames
+					log.Print("load state: not available while a movie is recording or playing")
+					return
+				}
+				if err := cpu.LoadStateFile(statePath); err != nil {
+					log.Printf("load state: %v", err)
+					return
+				}
+				log.Printf("loaded state from %s", statePath)
+			})
+		}),
+	}
+	for key, s := range speedKeys {
+		s := s
+		gpuOpts = append(gpuOpts, gpu.WithHotkey(key, func() {
+			selectedSpeed = s
+			cpu.SetSpeed(s)
+			log.Printf("speed %gx", s)
+		}))
+	}
+	for key, ch := range channelKeys {
+		ch := ch
+		gpuOpts = append(gpuOpts, gpu.WithHotkey(key, func() {
+			cpu.Do(func() {
+				sound.SetMuted(ch, !sound.Muted(ch))
+				log.Printf("channel %d muted: %v", ch, sound.Muted(ch))
+			})
+		}))
+	}
+	for key, button := range keys {
+		button := button
+		gpuOpts = append(gpuOpts, gpu.WithHoldKey(key, func(held bool) {
+			if held {
+				input.Press(button)
+			} else {
+				input.Release(button)
+			}
+		}))
+	}
+	gpu := gpu.New(gpuOpts...)
+	sound = apu.New(apu.WithSampleRate(*rate))
+	bootRom := boot
+	if gb.IsCGB(rom) {
+		// the embedded boot rom is the DMG one, which would tell the game
+		// it runs on a DMG
+		bootRom = nil
+	}
+	mmu := gb.NewMMU(bootRom, rom, gpu, sound)
+
+	if dev, err := audio.Open(player, sound.SampleRate()); err != nil {
+		log.Printf("audio: %v, continuing without sound", err)
+	} else {
+		defer dev.Close()
+		live = player.Sink()
+	}
+	sound.SetSink(live)
+
+	opts := []gb.Option{gb.WithSpeed(*speed)}
+	switch *unmap {
+	case "openbus":
+		opts = append(opts, gb.WithUnmappedPolicy(gb.UnmappedOpenBus))
+	case "stop":
+		opts = append(opts, gb.WithUnmappedPolicy(gb.UnmappedStop))
+	default:
+		log.Fatalf("unknown --unmapped policy %q", *unmap)
+	}
+	switch {
+	case *linkTo != "" && (*linkL != "" || *linkC != ""), *linkL != "" && *linkC != "":
+		log.Fatal("--link, --link-listen and --link-connect are exclusive")
+	case *linkTo == "printer":
+		p := printer.New(strings.TrimSuffix(*file, filepath.Ext(*file)) + "-print")
+		defer func() {
+			if err := p.Close(); err != nil {
+				log.Printf("printer: %v", err)
+			}
+		}()
+		opts = append(opts, gb.WithLink(p))
+	case *linkTo != "":
+		log.Fatalf("unknown --link device %q", *linkTo)
+	case *linkL != "":
+		log.Printf("waiting for a link cable on %s", *linkL)
+		conn, err := link.Listen(*linkL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		opts = append(opts, gb.WithLink(conn))
+	case *linkC != "":
+		conn, err := link.Dial(*linkC)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		opts = append(opts, gb.WithLink(conn))
+	}
+	if *trace != "" {
+		f, err := os.Create(*trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
 
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+		opts = append(opts, gb.WithTrace(w))
+	}
+
+	cpu = gb.NewCPU(mmu, gpu, *debug, opts...)
+	if bootRom == nil {
+		cpu.SkipBoot()
+	}
+
+	if *load != "" {
+		if err := cpu.LoadStateFile(*load); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	input = movie.NewInput(cpu)
+	if *playM != "" {
+		m, err := movie.ReadFile(*playM)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := input.Play(m); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *recMv != "" {
+		if err := input.Record(*load != ""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// created last so that nothing above can exit and leave it without a
+	// valid header
+	if *recAu != "" {
+		takes++
+		w, err := wav.Create(audioPath, sound.SampleRate())
+		if err != nil {
+			log.Fatal(err)
+		}
+		recorder = w
+		sound.SetSink(apu.Tee(live, recorder))
+	}
+	// finish saves the recordings, it must only run once emulation has
+	// stopped for good
+	finish := func() {
+		if recorder != nil {
+			sound.Flush()
+			if err := recorder.Close(); err != nil {
+				log.Printf("record audio: %v", err)
+			}
+		}
+		if *recMv != "" {
+			if err := input.StopRecording().WriteFile(*recMv); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+
+	// rewinding would desync the movie from the machine
+	if *rwnd > 0 && *recMv == "" && *playM == "" {
+		// one snapshot per frame
+		rewinder = rewind.New(cpu, int(float64(*rwnd)*gb.FrameRate), 1)
+		cpu.OnFrame(func() {
+			if err := rewinder.Frame(); err != nil {
+				log.Printf("rewind: %v", err)
+			}
+		})
+	}
+
+	// the debugger drives the cpu instead of letting it free run, closing
+	// the window stops it before the recordings are saved
+	if *repl {
+		r := debugger.NewREPL(debugger.New(cpu), os.Stdin, os.Stdout)
+		runDebugger(gpu, cpu, r.Run, r.Close)
+		finish()
+		return
+	}
+
+	if *gdbOn != "" {
+		srv := gdb.NewServer(debugger.New(cpu))
+		serve := func() {
+			if err := srv.ListenAndServe(*gdbOn); err != nil {
+				log.Print(err)
+			}
+		}
+		runDebugger(gpu, cpu, serve, srv.Close)
+		finish()
+		return
+	}
+
+	// Run only returns once the emulation goroutine has exited
 	pixelgl.Run(cpu.Run)
+	finish()
+}
+
+// takePath is the file for the nth audio recording of a session, recordings
+// after the first are numbered so they don't overwrite each other
+func takePath(path string, n int) string {
+	if n <= 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// runDebugger shows the screen while run drives the cpu on another
+// goroutine. Whichever ends first ends the other, runDebugger returns once
+// run has returned and the cpu is no longer in use.
+func runDebugger(g *gpu.GPU, cpu *gb.CPU, run func(), stop func()) {
+	stopped := make(chan struct{})
+	pixelgl.Run(func() {
+		go func() {
+			defer close(stopped)
+			run()
+			g.Close()
+		}()
+		g.Run(cpu)
+	})
+	stop()
+	<-stopped
 }
@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/faiface/pixel v0.10.0
+	github.com/hajimehoshi/oto v1.0.1
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/image v0.0.0-20190523035834-f03afa92d3ff
 )
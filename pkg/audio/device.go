@@ -0,0 +1,8 @@
+package audio
+
+import "github.com/prestonp/gbc/pkg/gb/apu"
+
+// Null is the sink used when there's no audio device, it discards everything
+type Null struct{}
+
+func (Null) WriteSamples(s []apu.Sample) {}
@@ -0,0 +1,19 @@
+//go:build noaudio || (!cgo && !windows)
+// +build noaudio !cgo,!windows
+
+package audio
+
+import "errors"
+
+// Device is unavailable in builds without an audio backend, the oto backend
+// needs cgo (and the ALSA headers on Linux) unless the noaudio tag is set
+type Device struct{}
+
+// Open always fails so that the caller falls back to Null
+func Open(p *Player, rate int) (*Device, error) {
+	return nil, errors.New("built without audio device support")
+}
+
+func (d *Device) Close() error {
+	return nil
+}
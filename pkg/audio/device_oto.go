@@ -0,0 +1,77 @@
+//go:build !noaudio && (cgo || windows)
+// +build !noaudio
+// +build cgo windows
+
+package audio
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/hajimehoshi/oto"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+)
+
+// Device feeds a Player to the default audio output
+type Device struct {
+	ctx    *oto.Context
+	out    *oto.Player
+	done   chan struct{}
+	closed sync.WaitGroup
+}
+
+// Open starts playing p at the sample rate on the default output, it fails
+// when there's no audio device
+func Open(p *Player, rate int) (*Device, error) {
+	// about a frame of latency in the device on top of the ring
+	period := rate / 60
+	ctx, err := oto.NewContext(rate, 2, 2, period*4*2)
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{ctx: ctx, out: ctx.NewPlayer(), done: make(chan struct{})}
+	d.closed.Add(1)
+	go d.run(p, period)
+	return d, nil
+}
+
+// run writes to the device, which blocks until it has room
+func (d *Device) run(p *Player, period int) {
+	defer d.closed.Done()
+	samples := make([]apu.Sample, period)
+	buf := make([]byte, period*4)
+	for {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+		p.Fill(samples)
+		for i, s := range samples {
+			binary.LittleEndian.PutUint16(buf[i*4:], uint16(pcm(s.L)))
+			binary.LittleEndian.PutUint16(buf[i*4+2:], uint16(pcm(s.R)))
+		}
+		if _, err := d.out.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+func pcm(v float32) int16 {
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	return int16(v * 32767)
+}
+
+func (d *Device) Close() error {
+	close(d.done)
+	d.closed.Wait()
+	if err := d.out.Close(); err != nil {
+		d.ctx.Close()
+		return err
+	}
+	return d.ctx.Close()
+}
@@ -0,0 +1,110 @@
+// Package audio plays the APU output live. The emulation pushes samples into
+// a ring buffer and the device pulls them back out at its own clock, which
+// never quite matches the video frame pacing. Dynamic rate control resamples
+// slightly faster or slower to keep the ring half full instead of letting it
+// drift into underruns or overruns.
+package audio
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+)
+
+// maxRateDelta is the largest adjustment to the playback rate, small enough
+// that the pitch change can't be heard
+const maxRateDelta = 0.005
+
+// Player resamples buffered samples for a device
+type Player struct {
+	ring   *apu.Ring
+	volume uint64 // float64 bits
+	muted  int32
+
+	// pos is how far playback is between prev and cur, in input samples
+	pos       float64
+	prev, cur apu.Sample
+	in        []apu.Sample
+}
+
+// NewPlayer buffers up to size samples
+func NewPlayer(size int) *Player {
+	p := &Player{ring: apu.NewRing(size)}
+	p.SetVolume(1)
+	return p
+}
+
+// Sink is where the APU should send its output
+func (p *Player) Sink() apu.AudioSink {
+	return p.ring
+}
+
+// SetVolume scales the output, 1 is unchanged
+func (p *Player) SetVolume(v float64) {
+	v = math.Max(0, math.Min(v, 1))
+	atomic.StoreUint64(&p.volume, math.Float64bits(v))
+}
+
+func (p *Player) Volume() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&p.volume))
+}
+
+// ToggleMute silences the output without pausing it and reports whether it's
+// now muted
+func (p *Player) ToggleMute() bool {
+	for {
+		m := atomic.LoadInt32(&p.muted)
+		if atomic.CompareAndSwapInt32(&p.muted, m, 1-m) {
+			return m == 0
+		}
+	}
+}
+
+func (p *Player) Muted() bool {
+	return atomic.LoadInt32(&p.muted) == 1
+}
+
+// Fill resamples buffered samples into out. It reads faster when the ring is
+// more than half full and slower when it's less, on an underrun the last
+// sample is held.
+func (p *Player) Fill(out []apu.Sample) {
+	fill := float64(p.ring.Len()) / float64(p.ring.Cap())
+	step := 1 + maxRateDelta*(2*fill-1)
+
+	gain := float32(p.Volume())
+	if p.Muted() {
+		gain = 0
+	}
+
+	// count the input samples this call consumes so that they can be read
+	// from the ring in one go
+	need, pos := 0, p.pos
+	for range out {
+		pos += step
+		for pos >= 1 {
+			pos--
+			need++
+		}
+	}
+	if cap(p.in) < need {
+		p.in = make([]apu.Sample, need)
+	}
+	in := p.in[:p.ring.Read(p.in[:need])]
+
+	for i := range out {
+		p.pos += step
+		for p.pos >= 1 {
+			p.pos--
+			p.prev = p.cur
+			if len(in) > 0 {
+				p.cur, in = in[0], in[1:]
+			}
+		}
+		t := float32(p.pos)
+		out[i] = apu.Sample{
+			L: (p.prev.L + (p.cur.L-p.prev.L)*t) * gain,
+			R: (p.prev.R + (p.cur.R-p.prev.R)*t) * gain,
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package audio
+
+import (
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/stretchr/testify/require"
+)
+
+func constant(n int, v float32) []apu.Sample {
+	s := make([]apu.Sample, n)
+	for i := range s {
+		s[i] = apu.Sample{L: v, R: -v}
+	}
+	return s
+}
+
+// consumed is the number of samples Fill reads from a ring holding fill
+// samples to produce n
+func consumed(fill, n int) int {
+	p := NewPlayer(1000)
+	p.Sink().WriteSamples(constant(fill, 0.5))
+	p.Fill(make([]apu.Sample, n))
+	return fill - p.ring.Len()
+}
+
+func TestDynamicRateControl(t *testing.T) {
+	require.Equal(t, 100, consumed(500, 100), "half full plays at the nominal rate")
+	require.Greater(t, consumed(900, 400), 400, "catches up when running full")
+	require.Less(t, consumed(100, 400), 400, "slows down when running dry")
+}
+
+func TestVolume(t *testing.T) {
+	p := NewPlayer(1000)
+	p.Sink().WriteSamples(constant(500, 0.5))
+	out := make([]apu.Sample, 10)
+
+	p.Fill(out)
+	require.Equal(t, apu.Sample{L: 0.5, R: -0.5}, out[9])
+
+	p.SetVolume(0.5)
+	p.Fill(out)
+	require.Equal(t, apu.Sample{L: 0.25, R: -0.25}, out[9])
+
+	p.SetVolume(2)
+	require.Equal(t, 1.0, p.Volume(), "clamped")
+
+	require.True(t, p.ToggleMute())
+	require.True(t, p.Muted())
+	p.Fill(out)
+	require.Equal(t, apu.Sample{}, out[9])
+	require.False(t, p.ToggleMute())
+}
+
+func TestUnderrunHoldsLastSample(t *testing.T) {
+	p := NewPlayer(1000)
+	p.Sink().WriteSamples(constant(5, 0.5))
+	out := make([]apu.Sample, 20)
+	p.Fill(out)
+	require.Zero(t, p.ring.Len())
+	require.Equal(t, apu.Sample{L: 0.5, R: -0.5}, out[19])
+}
@@ -0,0 +1,218 @@
+package debugger
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prestonp/gbc/pkg/disasm"
+	"github.com/prestonp/gbc/pkg/gb"
+)
+
+// StopReason describes why execution was handed back to the debugger
+type StopReason int
+
+const (
+	StopStep StopReason = iota
+	StopBreakpoint
+	StopInterrupt
+	StopWatchpoint
+	StopError
+)
+
+func (s StopReason) String() string {
+	switch s {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopInterrupt:
+		return "interrupt"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Debugger controls execution of a CPU one instruction at a time. It is not
+// safe to use while the CPU is running in another goroutine, the debugger is
+// expected to be the only thing calling CPU.Update.
+type Debugger struct {
+	cpu         *gb.CPU
+	breakpoints map[uint16]bool
+	interrupted int32
+
+	// hits holds the watchpoint accesses from the last stop
+	hits []gb.WatchHit
+
+	// err is the fault that caused the last StopError
+	err error
+}
+
+func New(cpu *gb.CPU) *Debugger {
+	return &Debugger{
+		cpu:         cpu,
+		breakpoints: make(map[uint16]bool),
+	}
+}
+
+func (d *Debugger) CPU() *gb.CPU {
+	return d.cpu
+}
+
+func (d *Debugger) AddBreakpoint(addr uint16) {
+	d.breakpoints[addr] = true
+}
+
+func (d *Debugger) RemoveBreakpoint(addr uint16) bool {
+	if !d.breakpoints[addr] {
+		return false
+	}
+	delete(d.breakpoints, addr)
+	return true
+}
+
+// Breakpoints returns the breakpoint addresses in ascending order
+func (d *Debugger) Breakpoints() []uint16 {
+	var addrs []uint16
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Watch adds a memory watchpoint, see gb.Watchpoint
+func (d *Debugger) Watch(w gb.Watchpoint) int {
+	return d.cpu.MMU.AddWatchpoint(w)
+}
+
+func (d *Debugger) Unwatch(id int) bool {
+	return d.cpu.MMU.RemoveWatchpoint(id)
+}
+
+// WatchHits returns the watchpoint accesses that caused the last stop
+func (d *Debugger) WatchHits() []gb.WatchHit {
+	return d.hits
+}
+
+// Err returns the fault that caused the last StopError
+func (d *Debugger) Err() error {
+	return d.err
+}
+
+// Interrupt stops a running Continue, StepOver or Finish, or the next one to
+// start if none is running yet. It is safe to call from another goroutine,
+// e.g. a signal handler.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupted, 1)
+}
+
+// ClearInterrupt discards an Interrupt that hasn't stopped anything. Call it
+// before starting a run on another goroutine, so that an Interrupt arriving
+// before the run gets going isn't lost.
+func (d *Debugger) ClearInterrupt() {
+	atomic.StoreInt32(&d.interrupted, 0)
+}
+
+// Peek reads memory without letting unmapped addresses crash the debugger
+func (d *Debugger) Peek(addr uint16) (b byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			b = 0xFF
+		}
+	}()
+	return d.cpu.MMU.Peek(addr)
+}
+
+// Poke writes a byte the way Peek reads it, without the side effects of an
+// access by the running program. It reports whether anything is mapped there.
+func (d *Debugger) Poke(addr uint16, b byte) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	return d.cpu.MMU.Poke(addr, b) == nil
+}
+
+// Disassembler decodes instructions out of the CPU's current memory map
+func (d *Debugger) Disassembler() *disasm.Disassembler {
+	return disasm.New(d.Peek)
+}
+
+// Step executes a single instruction
+func (d *Debugger) Step() StopReason {
+	d.cpu.RunTasks()
+	d.cpu.MMU.WatchHits()
+	if d.err = d.cpu.Update(); d.err != nil {
+		return StopError
+	}
+	if d.hits = d.cpu.MMU.WatchHits(); len(d.hits) > 0 {
+		return StopWatchpoint
+	}
+	return StopStep
+}
+
+// Continue runs until a breakpoint is reached or the debugger is interrupted
+func (d *Debugger) Continue() StopReason {
+	return d.runUntil(d.cpu.Update, func() bool { return false })
+}
+
+// StepOver executes the next instruction, running calls and restarts to
+// completion instead of stepping into them
+func (d *Debugger) StepOver() StopReason {
+	i := d.Disassembler().Decode(d.cpu.PC)
+	if !strings.HasPrefix(i.Mnemonic, "CALL") && !strings.HasPrefix(i.Mnemonic, "RST") {
+		return d.Step()
+	}
+
+	next := i.Addr + i.Len()
+	return d.runUntil(d.cpu.Update, func() bool { return d.cpu.PC == next })
+}
+
+// Finish runs until the current function returns to its caller
+func (d *Debugger) Finish() StopReason {
+	sp := d.cpu.SP
+	returned := false
+	step := func() error {
+		// a return pops the return address off the stack so SP ends up
+		// above where it was when the function was entered
+		i := d.Disassembler().Decode(d.cpu.PC)
+		ret := strings.HasPrefix(i.Mnemonic, "RET")
+		err := d.cpu.Update()
+		returned = ret && d.cpu.SP > sp
+		return err
+	}
+	return d.runUntil(step, func() bool { return returned })
+}
+
+// runUntil executes instructions until done returns true, a breakpoint or
+// watchpoint is hit, the CPU faults or the debugger is interrupted. The first
+// instruction always executes so that continuing from a breakpoint makes
+// progress.
+func (d *Debugger) runUntil(step func() error, done func() bool) StopReason {
+	d.cpu.MMU.WatchHits()
+	for {
+		// frontend work such as save state hotkeys queued with CPU.Do
+		d.cpu.RunTasks()
+		if d.err = step(); d.err != nil {
+			d.hits = nil
+			return StopError
+		}
+		d.hits = d.cpu.MMU.WatchHits()
+		switch {
+		case len(d.hits) > 0:
+			return StopWatchpoint
+		case done():
+			return StopStep
+		case d.breakpoints[d.cpu.PC]:
+			return StopBreakpoint
+		case atomic.CompareAndSwapInt32(&d.interrupted, 1, 0):
+			return StopInterrupt
+		}
+	}
+}
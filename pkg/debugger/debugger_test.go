@@ -0,0 +1,245 @@
+package debugger
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+// program calls a subroutine that increments B and then loops forever
+var program = []byte{
+	0x31, 0xFE, 0xFF, // 0100 LD SP, $FFFE
+	0xCD, 0x10, 0x01, // 0103 CALL $0110
+	0x3E, 0x42, // 0106 LD A, $42
+	0x18, 0xFE, // 0108 JR $0108
+	0, 0, 0, 0, 0, 0,
+	0x04, // 0110 INC B
+	0x04, // 0111 INC B
+	0xC9, // 0112 RET
+}
+
+func newTestCPU(t *testing.T) *gb.CPU {
+	t.Helper()
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], program)
+
+	gpu := gpu.New()
+	mmu := gb.NewMMU(nil, rom, gpu, apu.New())
+	mmu.WriteByte(0xFF50, 1) // unmap the boot rom
+	cpu := gb.NewCPU(mmu, gpu, false)
+	cpu.PC = 0x100
+	return cpu
+}
+
+func TestBreakpoints(t *testing.T) {
+	d := New(newTestCPU(t))
+	d.AddBreakpoint(0x111)
+	d.AddBreakpoint(0x108)
+	require.Equal(t, []uint16{0x108, 0x111}, d.Breakpoints())
+
+	require.Equal(t, StopBreakpoint, d.Continue())
+	require.EqualValues(t, 0x111, d.CPU().PC)
+	require.EqualValues(t, 1, d.CPU().R[gb.B])
+
+	require.Equal(t, StopBreakpoint, d.Continue())
+	require.EqualValues(t, 0x108, d.CPU().PC)
+	require.EqualValues(t, 2, d.CPU().R[gb.B])
+
+	require.True(t, d.RemoveBreakpoint(0x108))
+	require.False(t, d.RemoveBreakpoint(0x108))
+	require.Equal(t, []uint16{0x111}, d.Breakpoints())
+}
+
+func TestStepOver(t *testing.T) {
+	d := New(newTestCPU(t))
+	require.Equal(t, StopStep, d.StepOver())
+	require.EqualValues(t, 0x103, d.CPU().PC)
+
+	require.Equal(t, StopStep, d.StepOver())
+	require.EqualValues(t, 0x106, d.CPU().PC, "should run the call to completion")
+	require.EqualValues(t, 2, d.CPU().R[gb.B])
+
+	d = New(newTestCPU(t))
+	d.AddBreakpoint(0x111)
+	d.Step()
+	require.Equal(t, StopBreakpoint, d.StepOver(), "breakpoints inside the call still stop")
+	require.EqualValues(t, 0x111, d.CPU().PC)
+}
+
+func TestFinish(t *testing.T) {
+	d := New(newTestCPU(t))
+	d.Step()
+	d.Step()
+	require.EqualValues(t, 0x110, d.CPU().PC)
+
+	require.Equal(t, StopStep, d.Finish())
+	require.EqualValues(t, 0x106, d.CPU().PC)
+	require.EqualValues(t, 0xFFFE, d.CPU().SP)
+}
+
+func TestInterrupt(t *testing.T) {
+	d := New(newTestCPU(t))
+	d.AddBreakpoint(0x106)
+	d.Continue()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				d.Interrupt()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	require.Equal(t, StopInterrupt, d.Continue())
+	require.EqualValues(t, 0x108, d.CPU().PC)
+}
+
+func TestREPL(t *testing.T) {
+	in := strings.NewReader("b 0111\nc\nregs\nx $0100 3\nf\ns\n\nbl\nbogus\nq\n")
+	var out bytes.Buffer
+	NewREPL(New(newTestCPU(t)), in, &out).Run()
+
+	got := out.String()
+	require.Contains(t, got, "breakpoint at 0x0111")
+	require.Contains(t, got, "stopped: breakpoint at 0x0111")
+	require.Contains(t, got, "=> 0111  04        INC B")
+	require.Contains(t, got, "   0110  04        INC B")
+	require.Contains(t, got, "B:\t0x01")
+	require.Contains(t, got, "0100  31 FE FF")
+	require.Contains(t, got, "=> 0106  3E 42     LD A, $42")
+	require.Equal(t, 2, strings.Count(got, "=> 0108  18 FE     JR $0108"), "empty line repeats step")
+	require.Contains(t, got, "unknown command \"bogus\"")
+}
+
+func TestREPLClose(t *testing.T) {
+	in, w := io.Pipe()
+	defer w.Close()
+	var out bytes.Buffer
+	r := NewREPL(New(newTestCPU(t)), in, &out)
+
+	done := make(chan struct{})
+	go func() {
+		r.Run()
+		close(done)
+	}()
+	// the program loops forever, continue only stops when the REPL closes
+	_, err := io.WriteString(w, "c\n")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	r.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return")
+	}
+	require.Contains(t, out.String(), "stopped: interrupt")
+}
+
+func TestParseAddr(t *testing.T) {
+	for _, s := range []string{"150", "0150", "$0150", "0x150", "0X0150"} {
+		addr, err := ParseAddr(s)
+		require.NoError(t, err, s)
+		require.EqualValues(t, 0x150, addr, s)
+	}
+	_, err := ParseAddr("10000")
+	require.Error(t, err)
+	_, err = ParseAddr("nope")
+	require.Error(t, err)
+}
+
+func TestWatchpoint(t *testing.T) {
+	d := New(newTestCPU(t))
+	w, err := parseWatchpoint([]string{"FFFC-FFFD", "w"})
+	require.NoError(t, err)
+	d.Watch(w)
+
+	require.Equal(t, StopWatchpoint, d.Continue())
+	require.EqualValues(t, 0x110, d.CPU().PC, "stops after the call pushes the return address")
+	hits := d.WatchHits()
+	require.Len(t, hits, 2)
+	require.EqualValues(t, 0x103, hits[0].PC)
+	require.True(t, hits[0].Write)
+
+	in := strings.NewReader("w C000 rw =01\nwl\nuw 1\nuw 9\nwl\nq\n")
+	var out bytes.Buffer
+	NewREPL(d, in, &out).Run()
+	require.Contains(t, out.String(), "watchpoint 2 at 0xC000 rw =0x01")
+	require.Contains(t, out.String(), "2: 0xC000 rw =0x01")
+	require.Contains(t, out.String(), "no watchpoint 9")
+	require.Equal(t, 1, strings.Count(out.String(), "1: 0xFFFC-0xFFFD w"), "unwatched")
+
+	_, err = parseWatchpoint([]string{"C010-C000"})
+	require.Error(t, err)
+	_, err = parseWatchpoint([]string{"C000", "x"})
+	require.Error(t, err)
+}
+
+func TestStopError(t *testing.T) {
+	cpu := newTestCPU(t)
+	cpu.MMU.WriteByte(0xC000, 0x00)
+	cpu.MMU.WriteByte(0xC001, 0x08)
+	cpu.MMU.WriteByte(0xC002, 0x09)
+	cpu.PC = 0xC000
+
+	d := New(cpu)
+	require.Equal(t, StopError, d.Continue())
+	require.ErrorIs(t, d.Err(), gb.ErrUnimplementedOpcode)
+	require.EqualValues(t, 0xC001, cpu.PC, "stops on the failing opcode")
+
+	// stepping again fails the same way rather than running the operands
+	var out bytes.Buffer
+	NewREPL(d, strings.NewReader("s\n"), &out).Run()
+	require.Contains(t, out.String(), "stopped: error at 0xC001")
+	require.Contains(t, out.String(), "unimplemented opcode 0x08 at 0xC001")
+
+	// illegal opcodes hang the CPU, with the stop policy that's an error too
+	cpu = newTestCPU(t)
+	gb.WithUnmappedPolicy(gb.UnmappedStop)(cpu)
+	cpu.MMU.WriteByte(0xC000, 0x00)
+	cpu.MMU.WriteByte(0xC001, 0xD3)
+	cpu.PC = 0xC000
+
+	d = New(cpu)
+	require.Equal(t, StopError, d.Continue())
+	require.ErrorIs(t, d.Err(), gb.ErrIllegalOpcode)
+	require.EqualError(t, d.Err(), "illegal opcode 0xD3 at 0xC001")
+	require.True(t, cpu.Hung())
+}
+
+func TestInterruptBeforeRun(t *testing.T) {
+	d := New(newTestCPU(t))
+
+	// an interrupt arriving before the run starts isn't lost
+	d.ClearInterrupt()
+	d.Interrupt()
+	require.Equal(t, StopInterrupt, d.Continue())
+	require.EqualValues(t, 0x103, d.CPU().PC, "the first instruction still executes")
+
+	// it's consumed by the run it stopped
+	d.AddBreakpoint(0x106)
+	require.Equal(t, StopBreakpoint, d.Continue())
+}
+
+func TestRunsQueuedTasks(t *testing.T) {
+	d := New(newTestCPU(t))
+
+	ran := 0
+	for i := 0; i < 20; i++ {
+		d.CPU().Do(func() { ran++ })
+		d.Step()
+	}
+	require.Equal(t, 20, ran, "the queue never fills up")
+}
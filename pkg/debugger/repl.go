@@ -0,0 +1,373 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prestonp/gbc/pkg/disasm"
+	"github.com/prestonp/gbc/pkg/gb"
+)
+
+const help = `commands:
+  b, break ADDR      set a breakpoint
+  d, delete ADDR     remove a breakpoint
+  bl, breakpoints    list breakpoints
+  w, watch ADDR[-END] [r|w|rw] [=VAL]
+                     pause on memory access, default w
+  uw, unwatch ID     remove a watchpoint
+  wl, watchpoints    list watchpoints
+  s, step [N]        execute N instructions (default 1)
+  n, next            step over calls
+  c, continue        run until a breakpoint
+  f, finish          run until the current function returns
+  r, regs            dump registers
+  x ADDR [N]         dump N bytes of memory (default 64)
+  l, list [ADDR]     disassemble around ADDR (default PC)
+  q, quit            exit
+addresses are hex, e.g. 0150, $0150 or 0x0150
+an empty line repeats the previous command
+`
+
+// REPL is a line based front end to a Debugger
+type REPL struct {
+	d    *Debugger
+	in   *bufio.Scanner
+	out  io.Writer
+	last string
+
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewREPL(d *Debugger, in io.Reader, out io.Writer) *REPL {
+	return &REPL{
+		d:    d,
+		in:   bufio.NewScanner(in),
+		out:  out,
+		quit: make(chan struct{}),
+	}
+}
+
+// Run reads and executes commands until quit, the input is exhausted or
+// Close is called
+func (r *REPL) Run() {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for r.in.Scan() {
+			select {
+			case lines <- r.in.Text():
+			case <-r.quit:
+				return
+			}
+		}
+	}()
+
+	r.list(r.d.cpu.PC)
+	for {
+		fmt.Fprint(r.out, "(gbc) ")
+		var line string
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				return
+			}
+			line = l
+		case <-r.quit:
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = r.last
+		}
+		r.last = line
+
+		if quit := r.exec(line); quit {
+			return
+		}
+	}
+}
+
+// exec runs a single command and reports whether the REPL should exit
+func (r *REPL) exec(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case "b", "break":
+		addr, ok := r.addrArg(args, 0)
+		if !ok {
+			return false
+		}
+		r.d.AddBreakpoint(addr)
+		fmt.Fprintf(r.out, "breakpoint at 0x%04X\n", addr)
+	case "d", "delete":
+		addr, ok := r.addrArg(args, 0)
+		if !ok {
+			return false
+		}
+		if !r.d.RemoveBreakpoint(addr) {
+			fmt.Fprintf(r.out, "no breakpoint at 0x%04X\n", addr)
+		}
+	case "bl", "breakpoints":
+		for _, addr := range r.d.Breakpoints() {
+			fmt.Fprintf(r.out, "0x%04X\n", addr)
+		}
+	case "w", "watch":
+		w, err := parseWatchpoint(args)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			return false
+		}
+		id := r.d.Watch(w)
+		fmt.Fprintf(r.out, "watchpoint %d at %s\n", id, w)
+	case "uw", "unwatch":
+		if len(args) == 0 {
+			fmt.Fprintln(r.out, "missing watchpoint id")
+			return false
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil || !r.d.Unwatch(id) {
+			fmt.Fprintf(r.out, "no watchpoint %s\n", args[0])
+		}
+	case "wl", "watchpoints":
+		for _, w := range r.d.cpu.MMU.Watchpoints() {
+			fmt.Fprintf(r.out, "%d: %s\n", w.ID, w)
+		}
+	case "s", "step":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				fmt.Fprintf(r.out, "invalid count %q\n", args[0])
+				return false
+			}
+		}
+		reason := StopStep
+		for i := 0; i < n && reason == StopStep; i++ {
+			reason = r.d.Step()
+		}
+		r.stopped(reason)
+	case "n", "next":
+		r.run(r.d.StepOver)
+	case "c", "continue":
+		r.run(r.d.Continue)
+	case "f", "finish":
+		r.run(r.d.Finish)
+	case "r", "regs":
+		fmt.Fprint(r.out, r.d.cpu)
+	case "x":
+		addr, ok := r.addrArg(args, 0)
+		if !ok {
+			return false
+		}
+		n := 64
+		if len(args) > 1 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				fmt.Fprintf(r.out, "invalid length %q\n", args[1])
+				return false
+			}
+		}
+		r.dump(addr, n)
+	case "l", "list":
+		addr := r.d.cpu.PC
+		if len(args) > 0 {
+			var ok bool
+			if addr, ok = r.addrArg(args, 0); !ok {
+				return false
+			}
+		}
+		r.list(addr)
+	case "q", "quit":
+		return true
+	case "h", "help":
+		fmt.Fprint(r.out, help)
+	default:
+		fmt.Fprintf(r.out, "unknown command %q, try help\n", cmd)
+	}
+	return false
+}
+
+// run executes a command that runs until something stops it. Ctrl-C
+// interrupts it and drops back to the prompt, SIGINT is only captured while
+// it runs so that Ctrl-C at the prompt still exits.
+func (r *REPL) run(fn func() StopReason) {
+	r.d.ClearInterrupt()
+	select {
+	case <-r.quit:
+		// closed before the interrupt flag was cleared
+		return
+	default:
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sig:
+				r.d.Interrupt()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	reason := fn()
+	signal.Stop(sig)
+	close(done)
+	r.stopped(reason)
+}
+
+// Close makes Run return, stopping a running command. It is safe to call
+// from another goroutine, once Run has returned the REPL no longer touches the
+// CPU.
+func (r *REPL) Close() {
+	r.closeOnce.Do(func() {
+		close(r.quit)
+	})
+	r.d.Interrupt()
+}
+
+func (r *REPL) stopped(reason StopReason) {
+	if reason != StopStep {
+		fmt.Fprintf(r.out, "stopped: %s at 0x%04X\n", reason, r.d.cpu.PC)
+	}
+	if reason == StopError {
+		fmt.Fprintln(r.out, r.d.Err())
+	}
+	if reason == StopWatchpoint {
+		for _, hit := range r.d.WatchHits() {
+			fmt.Fprintln(r.out, hit)
+		}
+	}
+	r.list(r.d.cpu.PC)
+}
+
+// list prints a few instructions before and after addr. Decoding backwards
+// is ambiguous, so the listing starts at the earliest address that decodes
+// into a sequence landing exactly on addr.
+func (r *REPL) list(addr uint16) {
+	const before, after = 3, 5
+
+	d := r.d.Disassembler()
+	var is []disasm.Instruction
+	for start := int(addr) - before*3; start < int(addr); start++ {
+		if start < 0 {
+			continue
+		}
+		is = d.Range(uint16(start), int(addr))
+		if last := is[len(is)-1]; last.Addr+last.Len() == addr {
+			break
+		}
+		is = nil
+	}
+	if len(is) > before {
+		is = is[len(is)-before:]
+	}
+
+	for n, next := 0, addr; n < after; n++ {
+		i := d.Decode(next)
+		is = append(is, i)
+		next += i.Len()
+	}
+
+	for _, i := range is {
+		marker := "  "
+		if i.Addr == r.d.cpu.PC {
+			marker = "=>"
+		} else if r.d.breakpoints[i.Addr] {
+			marker = "* "
+		}
+		fmt.Fprintf(r.out, "%s %s\n", marker, i)
+	}
+}
+
+// dump prints memory 16 bytes per row
+func (r *REPL) dump(addr uint16, n int) {
+	for row := 0; row < n; row += 16 {
+		fmt.Fprintf(r.out, "%04X ", addr+uint16(row))
+		for col := row; col < row+16 && col < n; col++ {
+			fmt.Fprintf(r.out, " %02X", r.d.Peek(addr+uint16(col)))
+		}
+		fmt.Fprintln(r.out)
+	}
+}
+
+func (r *REPL) addrArg(args []string, idx int) (uint16, bool) {
+	if idx >= len(args) {
+		fmt.Fprintln(r.out, "missing address")
+		return 0, false
+	}
+	addr, err := ParseAddr(args[idx])
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return 0, false
+	}
+	return addr, true
+}
+
+// parseWatchpoint parses the arguments to the watch command, e.g.
+// `C000-C0FF rw` or `C100 w =05`
+func parseWatchpoint(args []string) (gb.Watchpoint, error) {
+	w := gb.Watchpoint{Write: true}
+	if len(args) == 0 {
+		return w, fmt.Errorf("missing address")
+	}
+
+	bounds := strings.SplitN(args[0], "-", 2)
+	var err error
+	if w.Start, err = ParseAddr(bounds[0]); err != nil {
+		return w, err
+	}
+	w.End = w.Start
+	if len(bounds) == 2 {
+		if w.End, err = ParseAddr(bounds[1]); err != nil {
+			return w, err
+		}
+		if w.End < w.Start {
+			return w, fmt.Errorf("invalid range %s", args[0])
+		}
+	}
+
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "r":
+			w.Read, w.Write = true, false
+		case arg == "w":
+			w.Read, w.Write = false, true
+		case arg == "rw":
+			w.Read, w.Write = true, true
+		case strings.HasPrefix(arg, "="):
+			v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(arg[1:]), "$"), "0x"), 16, 8)
+			if err != nil {
+				return w, fmt.Errorf("invalid value %q", arg)
+			}
+			w.Match, w.Value = true, byte(v)
+		default:
+			return w, fmt.Errorf("unknown watch option %q", arg)
+		}
+	}
+	return w, nil
+}
+
+// ParseAddr parses a hex address with an optional $ or 0x prefix
+func ParseAddr(s string) (uint16, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	n, err := strconv.ParseUint(trimmed, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return uint16(n), nil
+}
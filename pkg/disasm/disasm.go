@@ -0,0 +1,176 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instruction is a single decoded instruction
+type Instruction struct {
+	Addr     uint16
+	Bytes    []byte
+	Mnemonic string // instruction with its operands filled in, e.g. JP $0150
+
+	// Target is the resolved destination of a jump, call or restart
+	Target    uint16
+	HasTarget bool
+}
+
+// Len is the encoded size of the instruction in bytes
+func (i Instruction) Len() uint16 {
+	return uint16(len(i.Bytes))
+}
+
+// String formats the instruction as an address, its byte encoding and the
+// mnemonic, e.g. `0150  3E 12     LD A, $12`
+func (i Instruction) String() string {
+	enc := make([]string, len(i.Bytes))
+	for n, b := range i.Bytes {
+		enc[n] = fmt.Sprintf("%02X", b)
+	}
+	return fmt.Sprintf("%04X  %-9s %s", i.Addr, strings.Join(enc, " "), i.Mnemonic)
+}
+
+// Reader returns the byte at an address
+type Reader func(addr uint16) byte
+
+// ROM reads a cartridge rom with the given bank switched into 0x4000-0x7FFF.
+// Addresses outside of the rom read as 0xFF.
+func ROM(rom []byte, bank int) Reader {
+	return func(addr uint16) byte {
+		offset := int(addr)
+		switch {
+		case addr >= 0x8000:
+			return 0xFF
+		case addr >= 0x4000:
+			offset = bank*0x4000 + int(addr-0x4000)
+		}
+		if offset >= len(rom) {
+			return 0xFF
+		}
+		return rom[offset]
+	}
+}
+
+type Disassembler struct {
+	read    Reader
+	bank    int
+	symbols Symbols
+}
+
+type Option func(d *Disassembler)
+
+// WithSymbols labels addresses and jump targets with names from a symbol file
+func WithSymbols(s Symbols) Option {
+	return func(d *Disassembler) {
+		d.symbols = s
+	}
+}
+
+// WithBank sets the rom bank used to look up symbols in 0x4000-0x7FFF
+func WithBank(bank int) Option {
+	return func(d *Disassembler) {
+		d.bank = bank
+	}
+}
+
+func New(read Reader, opts ...Option) *Disassembler {
+	d := &Disassembler{
+		read: read,
+		bank: 1,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Label returns the symbol at addr if there is one
+func (d *Disassembler) Label(addr uint16) (string, bool) {
+	return d.symbols.Lookup(d.bank, addr)
+}
+
+// Decode decodes the instruction at addr
+func (d *Disassembler) Decode(addr uint16) Instruction {
+	op := d.read(addr)
+	i := Instruction{
+		Addr:  addr,
+		Bytes: []byte{op},
+	}
+
+	if op == 0xCB {
+		ext := d.read(addr + 1)
+		i.Bytes = append(i.Bytes, ext)
+		i.Mnemonic = cbOp(ext)
+		return i
+	}
+
+	mnemonic := ops[op]
+	if mnemonic == "" {
+		i.Mnemonic = fmt.Sprintf("ILLEGAL $%02X", op)
+		return i
+	}
+
+	switch {
+	case strings.Contains(mnemonic, "d16"), strings.Contains(mnemonic, "a16"):
+		lsb, msb := d.read(addr+1), d.read(addr+2)
+		i.Bytes = append(i.Bytes, lsb, msb)
+		word := uint16(msb)<<8 | uint16(lsb)
+		if strings.Contains(mnemonic, "a16") {
+			if strings.HasPrefix(mnemonic, "JP") || strings.HasPrefix(mnemonic, "CALL") {
+				i.Target, i.HasTarget = word, true
+			}
+			mnemonic = strings.Replace(mnemonic, "a16", d.address(word), 1)
+		} else {
+			mnemonic = strings.Replace(mnemonic, "d16", fmt.Sprintf("$%04X", word), 1)
+		}
+	case strings.Contains(mnemonic, "d8"):
+		n := d.read(addr + 1)
+		i.Bytes = append(i.Bytes, n)
+		mnemonic = strings.Replace(mnemonic, "d8", fmt.Sprintf("$%02X", n), 1)
+	case strings.Contains(mnemonic, "a8"):
+		n := d.read(addr + 1)
+		i.Bytes = append(i.Bytes, n)
+		mnemonic = strings.Replace(mnemonic, "a8", d.address(0xFF00+uint16(n)), 1)
+	case strings.Contains(mnemonic, "r8"):
+		n := d.read(addr + 1)
+		i.Bytes = append(i.Bytes, n)
+		offset := int8(n)
+		if strings.HasPrefix(mnemonic, "JR") {
+			i.Target, i.HasTarget = addr+2+uint16(offset), true
+			mnemonic = strings.Replace(mnemonic, "r8", d.address(i.Target), 1)
+		} else if offset < 0 {
+			mnemonic = strings.Replace(mnemonic, "+r8", fmt.Sprintf("-$%02X", -int(offset)), 1)
+			mnemonic = strings.Replace(mnemonic, "r8", fmt.Sprintf("-$%02X", -int(offset)), 1)
+		} else {
+			mnemonic = strings.Replace(mnemonic, "r8", fmt.Sprintf("$%02X", offset), 1)
+		}
+	case strings.HasPrefix(mnemonic, "RST"):
+		i.Target, i.HasTarget = uint16(op&0x38), true
+	}
+
+	i.Mnemonic = mnemonic
+	return i
+}
+
+// Range decodes every instruction starting in [from, to). to is an int so
+// that the range can extend to the end of the address space at 0x10000.
+func (d *Disassembler) Range(from uint16, to int) []Instruction {
+	var is []Instruction
+	for addr := int(from); addr < to && addr <= 0xFFFF; {
+		i := d.Decode(uint16(addr))
+		is = append(is, i)
+		addr += int(i.Len())
+	}
+	return is
+}
+
+// address formats an address operand, preferring its symbol when known
+func (d *Disassembler) address(addr uint16) string {
+	if label, ok := d.Label(addr); ok {
+		return label
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
@@ -0,0 +1,116 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0x00,             // NOP
+		0xC3, 0x50, 0x01, // JP $0150
+		0x3E, 0x12, // LD A, $12
+		0x20, 0xFE, // JR NZ, $0106
+		0xE0, 0x40, // LDH ($FF40), A
+		0xCB, 0x7C, // BIT 7, H
+		0xF8, 0xFB, // LD HL, SP-$05
+		0xD3, // illegal
+		0xEF, // RST 0x28
+	})
+
+	d := New(ROM(rom, 1))
+	is := d.Range(0x100, 0x110)
+
+	var got []string
+	for _, i := range is {
+		got = append(got, i.String())
+	}
+	require.Equal(t, []string{
+		"0100  00        NOP",
+		"0101  C3 50 01  JP $0150",
+		"0104  3E 12     LD A, $12",
+		"0106  20 FE     JR NZ, $0106",
+		"0108  E0 40     LDH ($FF40), A",
+		"010A  CB 7C     BIT 7, H",
+		"010C  F8 FB     LD HL, SP-$05",
+		"010E  D3        ILLEGAL $D3",
+		"010F  EF        RST 0x28",
+	}, got)
+
+	require.True(t, is[1].HasTarget)
+	require.EqualValues(t, 0x0150, is[1].Target)
+	require.EqualValues(t, 0x0106, is[3].Target)
+	require.EqualValues(t, 0x0028, is[8].Target)
+	require.False(t, is[2].HasTarget)
+}
+
+func TestCBPage(t *testing.T) {
+	require.Equal(t, "RLC B", cbOp(0x00))
+	require.Equal(t, "RL C", cbOp(0x11))
+	require.Equal(t, "SWAP A", cbOp(0x37))
+	require.Equal(t, "SRL (HL)", cbOp(0x3E))
+	require.Equal(t, "BIT 0, B", cbOp(0x40))
+	require.Equal(t, "RES 3, E", cbOp(0x9B))
+	require.Equal(t, "SET 7, A", cbOp(0xFF))
+}
+
+func TestBanks(t *testing.T) {
+	rom := make([]byte, 0x10000)
+	rom[0x4000] = 0x00 // NOP in bank 1
+	rom[0x8000] = 0x76 // HALT in bank 2
+
+	require.Equal(t, "NOP", New(ROM(rom, 1)).Decode(0x4000).Mnemonic)
+	require.Equal(t, "HALT", New(ROM(rom, 2)).Decode(0x4000).Mnemonic)
+	require.EqualValues(t, 0xFF, ROM(rom, 4)(0x4000), "banks past the end of the rom read as 0xFF")
+}
+
+func TestSymbols(t *testing.T) {
+	syms, err := ParseSymbols(strings.NewReader(`; File generated by rgblink
+00:0150 Start
+01:4000 BankedRoutine
+02:4000 OtherBank
+00:C000 wCounter
+`))
+	require.NoError(t, err)
+
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0xC3, 0x50, 0x01, // JP Start
+		0xCD, 0x00, 0x40, // CALL BankedRoutine
+		0xEA, 0x00, 0xC0, // LD (wCounter), A
+	})
+
+	d := New(ROM(rom, 1), WithSymbols(syms), WithBank(1))
+	is := d.Range(0x100, 0x109)
+	require.Equal(t, "JP Start", is[0].Mnemonic)
+	require.Equal(t, "CALL BankedRoutine", is[1].Mnemonic)
+	require.Equal(t, "LD (wCounter), A", is[2].Mnemonic)
+
+	label, ok := d.Label(0x150)
+	require.True(t, ok)
+	require.Equal(t, "Start", label)
+
+	_, err = ParseSymbols(strings.NewReader("0150 Start\n"))
+	require.Error(t, err)
+}
+
+func TestRangeEndOfAddressSpace(t *testing.T) {
+	read := func(addr uint16) byte {
+		if addr == 0xFFFD {
+			return 0x3E // LD A, d8 running past the end
+		}
+		return 0x00
+	}
+	d := New(read)
+
+	is := d.Range(0xFFFC, 0x10000)
+	require.Len(t, is, 3)
+	require.Equal(t, uint16(0xFFFC), is[0].Addr)
+	require.Equal(t, uint16(0xFFFD), is[1].Addr)
+	require.Equal(t, uint16(0xFFFF), is[2].Addr, "the last address is included")
+
+	require.Empty(t, d.Range(0x1234, 0x1234))
+}
@@ -0,0 +1,84 @@
+package disasm
+
+import "fmt"
+
+// Operand placeholders follow the notation used by the opcode tables in the
+// CPU manual and by the labels in the gb package:
+//
+//	d8  - immediate byte
+//	d16 - immediate word
+//	a8  - high ram offset from 0xFF00
+//	a16 - absolute address
+//	r8  - signed offset relative to the next instruction
+var ops = [256]string{
+	// 0x00
+	"NOP", "LD BC, d16", "LD (BC), A", "INC BC", "INC B", "DEC B", "LD B, d8", "RLCA",
+	"LD (a16), SP", "ADD HL, BC", "LD A, (BC)", "DEC BC", "INC C", "DEC C", "LD C, d8", "RRCA",
+	// 0x10
+	"STOP d8", "LD DE, d16", "LD (DE), A", "INC DE", "INC D", "DEC D", "LD D, d8", "RLA",
+	"JR r8", "ADD HL, DE", "LD A, (DE)", "DEC DE", "INC E", "DEC E", "LD E, d8", "RRA",
+	// 0x20
+	"JR NZ, r8", "LD HL, d16", "LD (HL+), A", "INC HL", "INC H", "DEC H", "LD H, d8", "DAA",
+	"JR Z, r8", "ADD HL, HL", "LD A, (HL+)", "DEC HL", "INC L", "DEC L", "LD L, d8", "CPL",
+	// 0x30
+	"JR NC, r8", "LD SP, d16", "LD (HL-), A", "INC SP", "INC (HL)", "DEC (HL)", "LD (HL), d8", "SCF",
+	"JR C, r8", "ADD HL, SP", "LD A, (HL-)", "DEC SP", "INC A", "DEC A", "LD A, d8", "CCF",
+	// 0x40
+	"LD B, B", "LD B, C", "LD B, D", "LD B, E", "LD B, H", "LD B, L", "LD B, (HL)", "LD B, A",
+	"LD C, B", "LD C, C", "LD C, D", "LD C, E", "LD C, H", "LD C, L", "LD C, (HL)", "LD C, A",
+	// 0x50
+	"LD D, B", "LD D, C", "LD D, D", "LD D, E", "LD D, H", "LD D, L", "LD D, (HL)", "LD D, A",
+	"LD E, B", "LD E, C", "LD E, D", "LD E, E", "LD E, H", "LD E, L", "LD E, (HL)", "LD E, A",
+	// 0x60
+	"LD H, B", "LD H, C", "LD H, D", "LD H, E", "LD H, H", "LD H, L", "LD H, (HL)", "LD H, A",
+	"LD L, B", "LD L, C", "LD L, D", "LD L, E", "LD L, H", "LD L, L", "LD L, (HL)", "LD L, A",
+	// 0x70
+	"LD (HL), B", "LD (HL), C", "LD (HL), D", "LD (HL), E", "LD (HL), H", "LD (HL), L", "HALT", "LD (HL), A",
+	"LD A, B", "LD A, C", "LD A, D", "LD A, E", "LD A, H", "LD A, L", "LD A, (HL)", "LD A, A",
+	// 0x80
+	"ADD A, B", "ADD A, C", "ADD A, D", "ADD A, E", "ADD A, H", "ADD A, L", "ADD A, (HL)", "ADD A, A",
+	"ADC A, B", "ADC A, C", "ADC A, D", "ADC A, E", "ADC A, H", "ADC A, L", "ADC A, (HL)", "ADC A, A",
+	// 0x90
+	"SUB B", "SUB C", "SUB D", "SUB E", "SUB H", "SUB L", "SUB (HL)", "SUB A",
+	"SBC A, B", "SBC A, C", "SBC A, D", "SBC A, E", "SBC A, H", "SBC A, L", "SBC A, (HL)", "SBC A, A",
+	// 0xA0
+	"AND B", "AND C", "AND D", "AND E", "AND H", "AND L", "AND (HL)", "AND A",
+	"XOR B", "XOR C", "XOR D", "XOR E", "XOR H", "XOR L", "XOR (HL)", "XOR A",
+	// 0xB0
+	"OR B", "OR C", "OR D", "OR E", "OR H", "OR L", "OR (HL)", "OR A",
+	"CP B", "CP C", "CP D", "CP E", "CP H", "CP L", "CP (HL)", "CP A",
+	// 0xC0
+	"RET NZ", "POP BC", "JP NZ, a16", "JP a16", "CALL NZ, a16", "PUSH BC", "ADD A, d8", "RST 0x00",
+	"RET Z", "RET", "JP Z, a16", "PREFIX CB", "CALL Z, a16", "CALL a16", "ADC A, d8", "RST 0x08",
+	// 0xD0
+	"RET NC", "POP DE", "JP NC, a16", "", "CALL NC, a16", "PUSH DE", "SUB d8", "RST 0x10",
+	"RET C", "RETI", "JP C, a16", "", "CALL C, a16", "", "SBC A, d8", "RST 0x18",
+	// 0xE0
+	"LDH (a8), A", "POP HL", "LD (C), A", "", "", "PUSH HL", "AND d8", "RST 0x20",
+	"ADD SP, r8", "JP (HL)", "LD (a16), A", "", "", "", "XOR d8", "RST 0x28",
+	// 0xF0
+	"LDH A, (a8)", "POP AF", "LD A, (C)", "DI", "", "PUSH AF", "OR d8", "RST 0x30",
+	"LD HL, SP+r8", "LD SP, HL", "LD A, (a16)", "EI", "", "", "CP d8", "RST 0x38",
+}
+
+var cbRegisters = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+var cbRotations = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+
+// cbOp returns the mnemonic of the instruction following a 0xCB prefix. The
+// extended page is regular enough to be derived from the opcode bits: the low
+// three bits select the register and the upper bits select the operation.
+func cbOp(op byte) string {
+	reg := cbRegisters[op&0x7]
+	bit := (op >> 3) & 0x7
+	switch op >> 6 {
+	case 0:
+		return fmt.Sprintf("%s %s", cbRotations[bit], reg)
+	case 1:
+		return fmt.Sprintf("BIT %d, %s", bit, reg)
+	case 2:
+		return fmt.Sprintf("RES %d, %s", bit, reg)
+	default:
+		return fmt.Sprintf("SET %d, %s", bit, reg)
+	}
+}
@@ -0,0 +1,68 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Symbols maps banked addresses to labels
+type Symbols map[uint32]string
+
+func symbolKey(bank int, addr uint16) uint32 {
+	return uint32(bank)<<16 | uint32(addr)
+}
+
+// ParseSymbols reads a symbol file in the `BB:AAAA Label` format emitted by
+// rgbds and wla-dx. Blank lines and `;` comments are skipped.
+func ParseSymbols(r io.Reader) (Symbols, error) {
+	syms := make(Symbols)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		// wla-dx groups symbols under section headers such as [labels]
+		if strings.HasPrefix(fields[0], "[") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("symbols line %d: missing label", line)
+		}
+
+		parts := strings.SplitN(fields[0], ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("symbols line %d: expected BB:AAAA, got %q", line, fields[0])
+		}
+		bank, err := strconv.ParseUint(parts[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("symbols line %d: bad bank: %w", line, err)
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("symbols line %d: bad address: %w", line, err)
+		}
+		syms[symbolKey(int(bank), uint16(addr))] = fields[1]
+	}
+	return syms, scanner.Err()
+}
+
+// Lookup returns the label at addr. Only 0x4000-0x7FFF is banked, everything
+// else is listed under bank 0.
+func (s Symbols) Lookup(bank int, addr uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	if addr < 0x4000 || addr >= 0x8000 {
+		bank = 0
+	}
+	label, ok := s[symbolKey(bank, addr)]
+	return label, ok
+}
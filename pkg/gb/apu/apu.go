@@ -1,136 +1,277 @@
 package apu
 
 import (
-	"fmt"
-	"log"
-
 	"github.com/prestonp/gbc/pkg/shared"
 )
 
+// sequencerPeriod is the number of cycles between frame sequencer steps,
+// which run at 512 Hz
+const sequencerPeriod = 8192
+
 type APU struct {
-	nr10 byte
-	nr11 byte
-	nr12 byte
-	nr22 byte // channel 2 volume envelope
-	nr23 byte // channel 2 frequency lo data
-	nr24 byte // channel 2 frequency hi data
-	nr30 byte // channel 3 sound on/off
-	nr42 byte // channel 4 volume envelope
-	nr44 byte // channel 4 counter/consecutive; initial
-	nr50 byte // unimplemented
-	nr51 byte // unimplemented
-	nr52 byte
+	ch1 square // tone and sweep
+	ch2 square // tone
+	ch3 wave   // wave pattern
+	ch4 noise  // noise
+
+	nr50 byte // master volume for the left (bits 4-6) and right (bits 0-2) terminals
+	nr51 byte // panning, bits 4-7 send channels 1-4 left and bits 0-3 right
+
+	power bool // NR52 bit 7, all registers are cleared while off
+	cgb   bool // Game Boy Color mode, without the DMG's quirks
+
+	// the frame sequencer clocks lengths at 256 Hz, sweeps at 128 Hz and
+	// envelopes at 64 Hz
+	seqTimer int // cycles until the next step
+	seqStep  int // next step, 0-7
+
+	mix mixer
 }
 
-func New() *APU {
-	return &APU{}
+// New returns an APU that is powered on, as the boot rom leaves it
+func New(opts ...Option) *APU {
+	a := &APU{}
+	a.mix.setRate(DefaultSampleRate)
+	a.setPower(true)
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
-func (a *APU) WriteByte(addr uint16, b byte) {
+func (a *APU) WriteByte(addr uint16, b byte) error {
+	if !a.power && addr <= 0xFF25 {
+		// registers can't be written while powered off, except for the
+		// length counters on the DMG
+		if a.cgb {
+			return nil
+		}
+		switch addr {
+		case 0xFF11:
+			a.ch1.Length.Counter = 64 - int(b&0x3F)
+		case 0xFF16:
+			a.ch2.Length.Counter = 64 - int(b&0x3F)
+		case 0xFF1B:
+			a.ch3.Length.Counter = 256 - int(b)
+		case 0xFF20:
+			a.ch4.Length.Counter = 64 - int(b&0x3F)
+		}
+		return nil
+	}
+
+	extraClock := a.seqStep%2 == 1
 	switch {
-	case addr == 0xFF10:
-		a.nr10 = b & 0x7F
-	case addr == 0xFF11:
-		a.nr11 = b
-	case addr == 0xFF12:
-		a.nr12 = b
-	case addr == 0xFF13:
-		fmt.Println("warning: write 0xFF13 not implemented")
-	case addr == 0xFF14:
-		fmt.Println("warning: write 0xFF14 not implemented")
-	case addr == 0xFF17:
-		a.nr22 = b
-	case addr == 0xFF18:
-		a.nr23 = b
-	case addr == 0xFF19:
-		a.nr24 = b
-	case addr == 0xFF1A:
-		a.nr30 = b
-	case addr == 0xFF21:
-		a.nr42 = b
-	case addr == 0xFF23:
-		a.nr44 = b
+	case addr >= 0xFF10 && addr <= 0xFF14:
+		a.ch1.write(int(addr-0xFF10), b, extraClock)
+		if addr == 0xFF14 && b&0x80 != 0 {
+			a.ch1.triggerSweep()
+		}
+	case addr >= 0xFF16 && addr <= 0xFF19:
+		a.ch2.write(int(addr-0xFF15), b, extraClock)
+	case addr >= 0xFF1A && addr <= 0xFF1E:
+		a.ch3.write(int(addr-0xFF1A), b, extraClock, a.cgb)
+	case addr >= 0xFF20 && addr <= 0xFF23:
+		a.ch4.write(int(addr-0xFF1F), b, extraClock)
 	case addr == 0xFF24:
 		a.nr50 = b
 	case addr == 0xFF25:
 		a.nr51 = b
 	case addr == 0xFF26:
-		a.nr52 = b & 0x8F
+		a.setPower(b&0x80 != 0)
+	case addr >= 0xFF30 && addr <= 0xFF3F:
+		a.ch3.writeRAM(int(addr-0xFF30), b, a.cgb)
 	default:
-		log.Panicf("unimplemented write sound controller register 0x%04X = 0x%02X\n", addr, b)
+		return shared.ErrUnmapped
 	}
+	return nil
 }
 
-func (a *APU) ReadByte(addr uint16) byte {
+func (a *APU) ReadByte(addr uint16) (byte, error) {
 	switch {
 	case addr == 0xFF10:
-		return a.nr10
+		return a.ch1.NR[0] | 0x80, nil
 	case addr == 0xFF11:
-		return a.nr11
+		return a.ch1.NR[1] | 0x3F, nil
 	case addr == 0xFF12:
-		return a.nr12
+		return a.ch1.NR[2], nil
 	case addr == 0xFF13:
-		log.Panicf("read 0xFF13 not implemented")
+		return 0xFF, nil
 	case addr == 0xFF14:
-		log.Panicf("read 0xFF14 not implemented")
+		return a.ch1.NR[4] | 0xBF, nil
+	case addr == 0xFF16:
+		return a.ch2.NR[1] | 0x3F, nil
 	case addr == 0xFF17:
-		return a.nr22
+		return a.ch2.NR[2], nil
 	case addr == 0xFF18:
-		return a.nr23
+		return 0xFF, nil
 	case addr == 0xFF19:
-		return a.nr24
+		return a.ch2.NR[4] | 0xBF, nil
 	case addr == 0xFF1A:
-		return a.nr30
+		return a.ch3.NR[0] | 0x7F, nil
+	case addr == 0xFF1B:
+		return 0xFF, nil
+	case addr == 0xFF1C:
+		return a.ch3.NR[2] | 0x9F, nil
+	case addr == 0xFF1D:
+		return 0xFF, nil
+	case addr == 0xFF1E:
+		return a.ch3.NR[4] | 0xBF, nil
+	case addr == 0xFF20:
+		return 0xFF, nil
 	case addr == 0xFF21:
-		return a.nr42
+		return a.ch4.NR[2], nil
+	case addr == 0xFF22:
+		return a.ch4.NR[3], nil
 	case addr == 0xFF23:
-		return a.nr44
+		return a.ch4.NR[4] | 0xBF, nil
 	case addr == 0xFF24:
-		return a.nr50
+		return a.nr50, nil
 	case addr == 0xFF25:
-		return a.nr51
+		return a.nr51, nil
 	case addr == 0xFF26:
-		return a.nr52
-	default:
-		log.Panicf("unimplemented read sound controller register 0x%04X\n", addr)
+		return a.status(), nil
+	case addr >= 0xFF30 && addr <= 0xFF3F:
+		return a.ch3.readRAM(int(addr-0xFF30), a.cgb), nil
 	}
-	log.Panicf("unexpected sound controller failure")
-	return 0
+	return 0, shared.ErrUnmapped
 }
 
-func (a *APU) Run(debugger shared.Debugger) {
-	log.Panicf("apu.Run not implemented, check if this requires handling timing")
+// status is NR52, the power bit and whether each channel is playing
+func (a *APU) status() byte {
+	b := byte(0x70)
+	if a.power {
+		b |= 0x80
+	}
+	if a.ch1.Enabled {
+		b |= 1 << 0
+	}
+	if a.ch2.Enabled {
+		b |= 1 << 1
+	}
+	if a.ch3.Enabled {
+		b |= 1 << 2
+	}
+	if a.ch4.Enabled {
+		b |= 1 << 3
+	}
+	return b
+}
+
+func (a *APU) setPower(on bool) {
+	if on == a.power {
+		return
+	}
+	a.power = on
+	if on {
+		a.seqTimer = sequencerPeriod
+		a.seqStep = 0
+		a.ch1.DutyStep = 0
+		a.ch2.DutyStep = 0
+		a.ch3.Sample = 0
+		return
+	}
+
+	// powering off clears every register, the DMG keeps the length
+	// counters and wave RAM isn't affected
+	ch1, ch2, ch3, ch4 := a.ch1.Length.Counter, a.ch2.Length.Counter, a.ch3.Length.Counter, a.ch4.Length.Counter
+	ram := a.ch3.RAM
+	*a = APU{seqTimer: sequencerPeriod, mix: a.mix, cgb: a.cgb}
+	a.ch3.RAM = ram
+	if a.cgb {
+		return
+	}
+	a.ch1.Length.Counter = ch1
+	a.ch2.Length.Counter = ch2
+	a.ch3.Length.Counter = ch3
+	a.ch4.Length.Counter = ch4
+}
+
+// SetCGB switches off the DMG's quirks: wave RAM is always reachable while
+// channel 3 plays, retriggering it doesn't corrupt wave RAM and powering off
+// clears the length counters too
+func (a *APU) SetCGB(on bool) {
+	a.cgb = on
+}
+
+// Run does nothing, the APU has no loop of its own and is stepped by the CPU
+func (a *APU) Run(debugger shared.Debugger) {}
+
+// Step advances the channels and the frame sequencer by a number of cycles
+// and mixes their output
+func (a *APU) Step(cycles int) {
+	if a.power {
+		a.ch1.step(cycles)
+		a.ch2.step(cycles)
+		a.ch3.step(cycles)
+		a.ch4.step(cycles)
+
+		a.seqTimer -= cycles
+		for a.seqTimer <= 0 {
+			a.seqTimer += sequencerPeriod
+			a.clockSequencer()
+		}
+	}
+	a.mix.scope.capture(cycles, a.channelLevels)
+	a.mixCycles(cycles)
+}
+
+func (a *APU) clockSequencer() {
+	step := a.seqStep
+	a.seqStep = (a.seqStep + 1) & 7
+
+	if step%2 == 0 {
+		if a.ch1.Length.clock() {
+			a.ch1.Enabled = false
+		}
+		if a.ch2.Length.clock() {
+			a.ch2.Enabled = false
+		}
+		if a.ch3.Length.clock() {
+			a.ch3.Enabled = false
+		}
+		if a.ch4.Length.clock() {
+			a.ch4.Enabled = false
+		}
+	}
+	if step == 2 || step == 6 {
+		a.ch1.clockSweep()
+	}
+	if step == 7 {
+		a.ch1.Env.clock(a.ch1.NR[2])
+		a.ch2.Env.clock(a.ch2.NR[2])
+		a.ch4.Env.clock(a.ch4.NR[2])
+	}
 }
 
 // 000: sweep off - no freq change 001: 7.8 ms (1/128Hz)
 // 010: 15.6 ms (2/128Hz)
 // 011: 23.4 ms (3/128Hz)
 func (a *APU) sweepTime() byte {
-	return a.nr10 >> 4
+	return a.ch1.NR[0] >> 4 & 7
 }
 
 // false: Addition (frequency increases)
 // true: Subtraction (frequency decreases)
 func (a *APU) sweepMode() bool {
-	return a.nr10&(1<<3) > 0
+	return a.ch1.NR[0]&(1<<3) > 0
 }
 
 func (a *APU) sweepShift() byte {
-	return a.nr10 & 0x7
+	return a.ch1.NR[0] & 0x7
 }
 
 func (a *APU) envelopeInitVolume() byte {
-	return a.nr12 >> 4
+	return a.ch1.NR[2] >> 4
 }
 
 // false: attenuate
 // true: amplify
 func (a *APU) envelopeMode() bool {
-	return a.nr12&0x8 == 0x8
+	return a.ch1.NR[2]&0x8 == 0x8
 }
 
 // false: attenuate
 // true: amplify
 func (a *APU) envelopeSweep() byte {
-	return a.nr12 & 0x7
+	return a.ch1.NR[2] & 0x7
 }
@@ -1,6 +1,7 @@
 package apu
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -36,3 +37,334 @@ func TestEnvelope(t *testing.T) {
 	require.EqualValues(t, false, apu.envelopeMode())
 	require.EqualValues(t, 0, apu.envelopeSweep())
 }
+
+func read(t *testing.T, apu *APU, addr uint16) byte {
+	t.Helper()
+	b, err := apu.ReadByte(addr)
+	require.NoError(t, err)
+	return b
+}
+
+func TestSquareRegisterReadBack(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF10, 0x00)
+	require.EqualValues(t, 0x80, read(t, apu, 0xFF10))
+	apu.WriteByte(0xFF11, 0x81)
+	require.EqualValues(t, 0xBF, read(t, apu, 0xFF11), "only the duty reads back")
+	apu.WriteByte(0xFF13, 0x12)
+	require.EqualValues(t, 0xFF, read(t, apu, 0xFF13), "write only")
+	apu.WriteByte(0xFF14, 0x07)
+	require.EqualValues(t, 0xBF, read(t, apu, 0xFF14))
+	apu.WriteByte(0xFF19, 0x47)
+	require.EqualValues(t, 0xFF, read(t, apu, 0xFF19))
+	require.EqualValues(t, 0xF0, read(t, apu, 0xFF26))
+}
+
+func TestSquareTrigger(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF16, 0x80) // 50% duty
+	apu.WriteByte(0xFF19, 0x80)
+	require.False(t, apu.ch2.Enabled, "triggering with the DAC off doesn't start the channel")
+
+	apu.WriteByte(0xFF17, 0xF0)
+	apu.WriteByte(0xFF18, 0x00)
+	apu.WriteByte(0xFF19, 0x87)
+	require.True(t, apu.ch2.Enabled)
+	require.EqualValues(t, 0xF2, read(t, apu, 0xFF26))
+	require.Equal(t, 64, apu.ch2.Length.Counter)
+
+	// the duty cycle advances every (2048-freq)*4 cycles
+	var out []byte
+	for i := 0; i < 8; i++ {
+		apu.Step(4 * 256)
+		out = append(out, apu.ch2.output())
+	}
+	require.Equal(t, []byte{0, 0, 0, 0, 15, 15, 15, 15}, out)
+
+	apu.WriteByte(0xFF17, 0x00)
+	require.False(t, apu.ch2.Enabled, "turning the DAC off stops the channel")
+}
+
+func TestSquareLength(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF12, 0xF0)
+	apu.WriteByte(0xFF11, 62) // 2 clocks
+	apu.WriteByte(0xFF14, 0xC0)
+	require.True(t, apu.ch1.Enabled)
+
+	apu.Step(sequencerPeriod) // step 0 clocks length
+	require.True(t, apu.ch1.Enabled)
+	apu.Step(2 * sequencerPeriod) // step 2 clocks length
+	require.False(t, apu.ch1.Enabled)
+
+	// enabling length while the next step doesn't clock it clocks it once
+	require.Equal(t, 3, apu.seqStep)
+	apu.WriteByte(0xFF11, 63)
+	apu.WriteByte(0xFF14, 0x80)
+	require.True(t, apu.ch1.Enabled)
+	apu.WriteByte(0xFF14, 0x40)
+	require.False(t, apu.ch1.Enabled)
+}
+
+func TestSquareEnvelope(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF17, 0x09) // volume 0, increase every clock
+	apu.WriteByte(0xFF19, 0x80)
+	require.True(t, apu.ch2.Enabled)
+
+	apu.Step(8 * sequencerPeriod)
+	require.EqualValues(t, 1, apu.ch2.Env.Volume)
+	apu.Step(8 * sequencerPeriod)
+	require.EqualValues(t, 2, apu.ch2.Env.Volume)
+
+	apu.WriteByte(0xFF17, 0x22) // volume 2, decrease every other clock
+	apu.WriteByte(0xFF19, 0x80)
+	apu.Step(8 * sequencerPeriod)
+	require.EqualValues(t, 2, apu.ch2.Env.Volume)
+	apu.Step(8 * sequencerPeriod)
+	require.EqualValues(t, 1, apu.ch2.Env.Volume)
+}
+
+func TestFrequencySweep(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF12, 0xF0)
+	apu.WriteByte(0xFF10, 0x11) // period 1, add, shift 1
+	apu.WriteByte(0xFF13, 0x00)
+	apu.WriteByte(0xFF14, 0x81) // 0x100
+	require.True(t, apu.ch1.Enabled)
+
+	apu.Step(3 * sequencerPeriod) // steps 0-2
+	require.EqualValues(t, 0x180, apu.ch1.freq())
+	apu.Step(4 * sequencerPeriod) // steps 3-6
+	require.EqualValues(t, 0x240, apu.ch1.freq())
+
+	// a trigger that would overflow straight away disables the channel
+	apu.WriteByte(0xFF10, 0x01)
+	apu.WriteByte(0xFF13, 0xFF)
+	apu.WriteByte(0xFF14, 0x87)
+	require.False(t, apu.ch1.Enabled)
+
+	// leaving negate mode after subtracting disables the channel
+	apu.WriteByte(0xFF10, 0x19)
+	apu.WriteByte(0xFF14, 0x84)
+	require.True(t, apu.ch1.Enabled)
+	apu.WriteByte(0xFF10, 0x11)
+	require.False(t, apu.ch1.Enabled)
+}
+
+func TestPower(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF12, 0xF0)
+	apu.WriteByte(0xFF14, 0x80)
+	apu.WriteByte(0xFF26, 0x00)
+	require.EqualValues(t, 0x70, read(t, apu, 0xFF26))
+	require.EqualValues(t, 0x00, read(t, apu, 0xFF12))
+
+	apu.WriteByte(0xFF12, 0xF0)
+	require.EqualValues(t, 0x00, read(t, apu, 0xFF12), "writes are ignored while off")
+
+	apu.WriteByte(0xFF26, 0x80)
+	apu.WriteByte(0xFF12, 0xF0)
+	require.EqualValues(t, 0xF0, read(t, apu, 0xFF12))
+}
+
+func TestWaveChannel(t *testing.T) {
+	apu := New()
+	for i := uint16(0); i < 16; i++ {
+		apu.WriteByte(0xFF30+i, byte(i)<<4|byte(15-i))
+	}
+	require.EqualValues(t, 0x1E, read(t, apu, 0xFF31))
+
+	apu.WriteByte(0xFF1A, 0x80)
+	apu.WriteByte(0xFF1C, 0x20) // full volume
+	apu.WriteByte(0xFF1D, 0x00)
+	apu.WriteByte(0xFF1E, 0x87)
+	require.True(t, apu.ch3.Enabled)
+	require.EqualValues(t, 0xF4, read(t, apu, 0xFF26))
+	require.EqualValues(t, 0xBF, read(t, apu, 0xFF1C))
+
+	// samples advance every (2048-freq)*2 cycles after a short delay
+	period := 2 * 256
+	apu.Step(period + 6)
+	require.EqualValues(t, 1, apu.ch3.Position)
+	require.EqualValues(t, 0x0F, apu.ch3.output())
+	apu.Step(period)
+	require.EqualValues(t, 0x01, apu.ch3.output())
+
+	apu.WriteByte(0xFF1C, 0x40) // 50%
+	require.EqualValues(t, 0x00, apu.ch3.output())
+	apu.Step(period)
+	require.EqualValues(t, 0x07, apu.ch3.output())
+	apu.WriteByte(0xFF1C, 0x60) // 25%
+	require.EqualValues(t, 0x03, apu.ch3.output())
+
+	// while playing, the CPU only reaches wave RAM in the cycle the
+	// channel reads it
+	apu.Step(period / 2)
+	require.EqualValues(t, 0xFF, read(t, apu, 0xFF30))
+	apu.WriteByte(0xFF30, 0x55)
+	require.EqualValues(t, 0x0F, apu.ch3.RAM[0], "write is lost")
+	apu.Step(period / 2)
+	require.EqualValues(t, 0x2D, read(t, apu, 0xFF3F), "reads the byte being played")
+
+	apu.WriteByte(0xFF1A, 0x00)
+	require.False(t, apu.ch3.Enabled, "DAC off")
+	require.EqualValues(t, 0xFF, read(t, apu, 0xFF1B))
+}
+
+func TestWaveLength(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF1A, 0x80)
+	apu.WriteByte(0xFF1B, 255) // 1 clock
+	apu.WriteByte(0xFF1E, 0xC0)
+	require.True(t, apu.ch3.Enabled)
+	apu.Step(sequencerPeriod)
+	require.False(t, apu.ch3.Enabled)
+
+	apu.WriteByte(0xFF1E, 0x80)
+	require.Equal(t, 256, apu.ch3.Length.Counter)
+}
+
+func TestWaveRetriggerCorruption(t *testing.T) {
+	apu := New()
+	for i := uint16(0); i < 16; i++ {
+		apu.WriteByte(0xFF30+i, byte(i))
+	}
+	apu.WriteByte(0xFF1A, 0x80)
+	apu.WriteByte(0xFF1E, 0x87)
+
+	// play up to just before sample 10 is read, byte 5 of wave RAM
+	apu.Step(256*2 + 6 + 8*256*2 + 256*2 - 2)
+	require.EqualValues(t, 9, apu.ch3.Position)
+	apu.WriteByte(0xFF1E, 0x87)
+	require.Equal(t, []byte{4, 5, 6, 7, 4, 5, 6, 7}, apu.ch3.RAM[:8])
+}
+
+func TestWaveRAMSurvivesPowerOff(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF30, 0xAB)
+	apu.WriteByte(0xFF26, 0x00)
+	require.EqualValues(t, 0xAB, read(t, apu, 0xFF30))
+}
+
+// outputBits shifts the LFSR n times and collects its output, 1 while the
+// channel is high
+func outputBits(c *noise, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		c.shift()
+		if c.LFSR&1 == 0 {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestLFSRSequences(t *testing.T) {
+	c := &noise{LFSR: 0x7FFF}
+	require.Equal(t, "000000000000001111111111111101111111111111001111", outputBits(c, 48))
+
+	c = &noise{LFSR: 0x7FFF}
+	c.NR[3] = 0x08
+	require.Equal(t, "000000111111011111001111010111000011011101001100", outputBits(c, 48))
+
+	// both modes are maximal length sequences
+	for _, tc := range []struct {
+		nr43   byte
+		period int
+	}{{0x00, 32767}, {0x08, 127}} {
+		c := &noise{LFSR: 0x7FFF}
+		c.NR[3] = tc.nr43
+		start := outputBits(c, 200)
+		c = &noise{LFSR: 0x7FFF}
+		c.NR[3] = tc.nr43
+		outputBits(c, tc.period)
+		require.Equal(t, start, outputBits(c, 200), "period %d", tc.period)
+	}
+}
+
+func TestNoiseChannel(t *testing.T) {
+	apu := New()
+	apu.WriteByte(0xFF21, 0xF0)
+	apu.WriteByte(0xFF22, 0x21) // divisor 16, shift 2
+	apu.WriteByte(0xFF20, 0x3F)
+	apu.WriteByte(0xFF23, 0xC0)
+	require.True(t, apu.ch4.Enabled)
+	require.EqualValues(t, 0xF8, read(t, apu, 0xFF26))
+	require.EqualValues(t, 0xFF, read(t, apu, 0xFF20))
+	require.EqualValues(t, 0x21, read(t, apu, 0xFF22))
+	require.EqualValues(t, 0xFF, read(t, apu, 0xFF23))
+
+	require.Equal(t, 64, apu.ch4.period())
+	apu.Step(64 * 14)
+	require.EqualValues(t, 0x0001, apu.ch4.LFSR)
+	require.EqualValues(t, 0, apu.ch4.output())
+	apu.Step(64)
+	require.EqualValues(t, 15, apu.ch4.output())
+
+	// clock shifts 14 and 15 stop the LFSR
+	apu.WriteByte(0xFF22, 0xE0)
+	lfsr := apu.ch4.LFSR
+	apu.Step(100000)
+	require.Equal(t, lfsr, apu.ch4.LFSR)
+
+	// the length of 1 ran out, retriggering reloads it to 64
+	require.False(t, apu.ch4.Enabled)
+	require.Zero(t, apu.ch4.Length.Counter)
+	apu.WriteByte(0xFF23, 0x80)
+	require.True(t, apu.ch4.Enabled)
+	require.Equal(t, 64, apu.ch4.Length.Counter)
+}
+
+func TestCGBWaveRAM(t *testing.T) {
+	apu := New()
+	apu.SetCGB(true)
+	for i := uint16(0); i < 16; i++ {
+		apu.WriteByte(0xFF30+i, byte(i))
+	}
+	apu.WriteByte(0xFF1A, 0x80)
+	apu.WriteByte(0xFF1E, 0x87)
+
+	// wave RAM is reachable at any time while playing, not just in the
+	// cycle the channel reads it
+	period := 2 * 256
+	apu.Step(period + 6 + 2*period + period/2)
+	require.EqualValues(t, 3, apu.ch3.Position)
+	require.EqualValues(t, 1, read(t, apu, 0xFF30), "reads the byte being played")
+	apu.WriteByte(0xFF30, 0x55)
+	require.EqualValues(t, 0x55, apu.ch3.RAM[1])
+
+	// retriggering doesn't corrupt wave RAM
+	apu.Step(6*period + period/2 - 2)
+	require.EqualValues(t, 9, apu.ch3.Position)
+	apu.WriteByte(0xFF1E, 0x87)
+	require.Equal(t, []byte{0, 0x55, 2, 3, 4, 5, 6, 7}, apu.ch3.RAM[:8])
+}
+
+func TestLengthAtPowerOff(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cgb  bool
+		want int
+	}{
+		{"dmg", false, 64 - 0x10},
+		{"cgb", true, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			apu := New()
+			apu.SetCGB(tc.cgb)
+			apu.WriteByte(0xFF11, 0x10)
+			apu.WriteByte(0xFF26, 0x00)
+			require.Equal(t, tc.want, apu.ch1.Length.Counter, "kept on power off")
+
+			apu.WriteByte(0xFF16, 0x20)
+			if tc.cgb {
+				require.Zero(t, apu.ch2.Length.Counter, "written while off")
+			} else {
+				require.Equal(t, 64-0x20, apu.ch2.Length.Counter, "written while off")
+			}
+		})
+	}
+}
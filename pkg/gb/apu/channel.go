@@ -0,0 +1,73 @@
+package apu
+
+// The channel building blocks below are shared by all four channels. Their
+// fields are exported so that save states can encode them as they are.
+
+// length silences a channel once its counter, loaded from NRx1, runs out. It
+// is clocked at 256 Hz while enabled by bit 6 of NRx4.
+type length struct {
+	Enabled bool
+	Counter int
+}
+
+// clock counts down and reports whether the channel should be disabled
+func (l *length) clock() bool {
+	if !l.Enabled || l.Counter == 0 {
+		return false
+	}
+	l.Counter--
+	return l.Counter == 0
+}
+
+// write handles the length bits of an NRx4 write and reports whether the
+// channel should be disabled. When the frame sequencer's next step doesn't
+// clock lengths, enabling the counter clocks it once immediately and a
+// trigger that reloads it starts one short.
+func (l *length) write(enable, trigger bool, max int, extraClock bool) bool {
+	wasEnabled := l.Enabled
+	l.Enabled = enable
+
+	disable := false
+	if extraClock && !wasEnabled && enable && l.Counter > 0 {
+		l.Counter--
+		disable = l.Counter == 0 && !trigger
+	}
+	if trigger && l.Counter == 0 {
+		l.Counter = max
+		if enable && extraClock {
+			l.Counter--
+		}
+	}
+	return disable
+}
+
+// envelope ramps a channel's volume up or down, clocked at 64 Hz
+type envelope struct {
+	Volume byte
+	Timer  byte
+}
+
+// trigger reloads the volume and period from NRx2
+func (e *envelope) trigger(nrx2 byte) {
+	e.Volume = nrx2 >> 4
+	e.Timer = nrx2 & 7
+}
+
+func (e *envelope) clock(nrx2 byte) {
+	period := nrx2 & 7
+	if period == 0 {
+		return
+	}
+	if e.Timer > 0 {
+		e.Timer--
+	}
+	if e.Timer > 0 {
+		return
+	}
+	e.Timer = period
+	if nrx2&0x08 != 0 && e.Volume < 15 {
+		e.Volume++
+	} else if nrx2&0x08 == 0 && e.Volume > 0 {
+		e.Volume--
+	}
+}
@@ -0,0 +1,206 @@
+package apu
+
+import "math"
+
+// clockSpeed is the rate the APU is stepped at, in cycles per second
+const clockSpeed = 4194304
+
+// DefaultSampleRate is the host rate output is resampled to unless
+// WithSampleRate picks another
+const DefaultSampleRate = 48000
+
+// batchSize is the number of samples collected before they're handed to the
+// sink
+const batchSize = 512
+
+// Sample is one stereo output frame, each side in [-1, 1]
+type Sample struct {
+	L, R float32
+}
+
+// AudioSink consumes the mixed output. WriteSamples is called from the
+// emulation goroutine and must not keep s after it returns.
+type AudioSink interface {
+	WriteSamples(s []Sample)
+}
+
+type Option func(a *APU)
+
+// WithSampleRate sets the host sample rate, e.g. 44100 or 48000
+func WithSampleRate(rate int) Option {
+	return func(a *APU) {
+		a.mix.setRate(rate)
+	}
+}
+
+// WithSink sends the mixed output to sink
+func WithSink(sink AudioSink) Option {
+	return func(a *APU) {
+		a.mix.sink = sink
+	}
+}
+
+// mixer pans the four channels through NR51, scales them by the NR50 master
+// volume, removes their DC offset with the same high-pass filter as the
+// hardware and resamples the result to the host rate
+type mixer struct {
+	sink AudioSink
+	rate int
+	out  []Sample
+
+	// phase counts towards the next output sample in units of
+	// 1/(clockSpeed*rate) seconds, the sums average the mix over the cycles
+	// since the last sample
+	phase      int
+	sumL, sumR float64
+	count      int
+	charge     float64 // high-pass capacitor charge factor per sample
+	capL, capR float64
+
+	muted [4]bool // channels left out of the mix
+	scope scope
+}
+
+func (m *mixer) setRate(rate int) {
+	m.rate = rate
+	m.charge = math.Pow(0.999958, float64(clockSpeed)/float64(rate))
+}
+
+// SampleRate is the host sample rate output is resampled to
+func (a *APU) SampleRate() int {
+	return a.mix.rate
+}
+
+// SetSink replaces the sink the output goes to, nil discards it. It must be
+// called from the emulation goroutine, e.g. through CPU.Do.
+func (a *APU) SetSink(sink AudioSink) {
+	a.Flush()
+	a.mix.sink = sink
+}
+
+// Flush hands any samples that haven't filled a batch yet to the sink
+func (a *APU) Flush() {
+	m := &a.mix
+	if m.sink != nil && len(m.out) > 0 {
+		m.sink.WriteSamples(m.out)
+	}
+	m.out = m.out[:0]
+}
+
+// dac converts a channel's 4 bit output to an analog level in [-1, 1], a
+// channel whose DAC is off outputs 0
+func dac(on bool, v byte) float64 {
+	if !on {
+		return 0
+	}
+	return float64(v)/7.5 - 1
+}
+
+// channelLevels is the current analog output of each channel
+func (a *APU) channelLevels() [4]float64 {
+	if !a.power {
+		return [4]float64{}
+	}
+	return [4]float64{
+		dac(a.ch1.dac(), a.ch1.output()),
+		dac(a.ch2.dac(), a.ch2.output()),
+		dac(a.ch3.dac(), a.ch3.output()),
+		dac(a.ch4.dac(), a.ch4.output()),
+	}
+}
+
+// levels is the current analog output of the left and right terminals
+func (a *APU) levels() (l, r float64) {
+	for i, v := range a.channelLevels() {
+		if a.mix.muted[i] {
+			continue
+		}
+		if a.nr51&(0x10<<i) != 0 {
+			l += v
+		}
+		if a.nr51&(1<<i) != 0 {
+			r += v
+		}
+	}
+	// the channels are averaged so that the mix stays in [-1, 1]
+	l *= float64(a.nr50>>4&7+1) / 8 / 4
+	r *= float64(a.nr50&7+1) / 8 / 4
+	return l, r
+}
+
+// mixCycles holds the current output for a number of cycles, emitting
+// samples whenever a host sample period has passed
+func (a *APU) mixCycles(cycles int) {
+	m := &a.mix
+	if m.sink == nil {
+		return
+	}
+	l, r := a.levels()
+	for cycles > 0 {
+		n := (clockSpeed - m.phase + m.rate - 1) / m.rate
+		if n > cycles {
+			n = cycles
+		}
+		m.sumL += l * float64(n)
+		m.sumR += r * float64(n)
+		m.count += n
+		m.phase += n * m.rate
+		cycles -= n
+
+		if m.phase >= clockSpeed {
+			m.phase -= clockSpeed
+			m.emit()
+		}
+	}
+}
+
+func (m *mixer) emit() {
+	l := m.sumL / float64(m.count)
+	r := m.sumR / float64(m.count)
+	m.sumL, m.sumR, m.count = 0, 0, 0
+
+	outL := l - m.capL
+	m.capL = l - outL*m.charge
+	outR := r - m.capR
+	m.capR = r - outR*m.charge
+
+	m.out = append(m.out, Sample{float32(outL), float32(outR)})
+	if len(m.out) >= batchSize {
+		m.sink.WriteSamples(m.out)
+		m.out = m.out[:0]
+	}
+}
+
+// SetMuted leaves channel ch, 1-4, out of the mix. Unlike the sound
+// registers this is a host setting, it isn't saved and survives power off.
+func (a *APU) SetMuted(ch int, muted bool) {
+	a.mix.muted[ch-1] = muted
+}
+
+// Muted reports whether channel ch, 1-4, is left out of the mix
+func (a *APU) Muted(ch int) bool {
+	return a.mix.muted[ch-1]
+}
+
+// Tee sends the output to every non-nil sink, e.g. a live backend and a
+// recording
+func Tee(sinks ...AudioSink) AudioSink {
+	var t tee
+	for _, s := range sinks {
+		if s != nil {
+			t = append(t, s)
+		}
+	}
+	if len(t) == 0 {
+		return nil
+	}
+	return t
+}
+
+type tee []AudioSink
+
+func (t tee) WriteSamples(s []Sample) {
+	for _, sink := range t {
+		sink.WriteSamples(s)
+	}
+}
@@ -0,0 +1,107 @@
+package apu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// collect is a sink that keeps everything it's given
+type collect []Sample
+
+func (c *collect) WriteSamples(s []Sample) {
+	*c = append(*c, s...)
+}
+
+// playSquare starts a 512 Hz tone on channel 2 at full volume with a 50%
+// duty, frequency 0x700 is 131072/(2048-0x700) Hz
+func playSquare(a *APU) {
+	a.WriteByte(0xFF17, 0xF0)
+	a.WriteByte(0xFF16, 0x80)
+	a.WriteByte(0xFF18, 0x00)
+	a.WriteByte(0xFF19, 0x87)
+}
+
+func peak(s []Sample) (l, r float64) {
+	for _, v := range s {
+		l = math.Max(l, math.Abs(float64(v.L)))
+		r = math.Max(r, math.Abs(float64(v.R)))
+	}
+	return l, r
+}
+
+func TestMixerSampleRate(t *testing.T) {
+	for _, rate := range []int{44100, 48000} {
+		var out collect
+		a := New(WithSampleRate(rate), WithSink(&out))
+		require.Equal(t, rate, a.SampleRate())
+		for i := 0; i < clockSpeed/4; i++ {
+			a.Step(4)
+		}
+		a.Flush()
+		require.Len(t, out, rate, "rate %d", rate)
+	}
+}
+
+func TestMixerPanning(t *testing.T) {
+	var out collect
+	a := New(WithSink(&out))
+	a.WriteByte(0xFF24, 0x77)
+	a.WriteByte(0xFF25, 0x20) // channel 2 left only
+	playSquare(a)
+	a.Step(clockSpeed / 10)
+	a.Flush()
+
+	l, r := peak(out)
+	require.Greater(t, l, 0.1)
+	require.Zero(t, r)
+}
+
+func TestMixerMasterVolume(t *testing.T) {
+	levels := map[byte]float64{}
+	for _, nr50 := range []byte{0x77, 0x00} {
+		var out collect
+		a := New(WithSink(&out))
+		a.WriteByte(0xFF24, nr50)
+		a.WriteByte(0xFF25, 0xFF)
+		playSquare(a)
+		a.Step(clockSpeed / 10)
+		a.Flush()
+		levels[nr50], _ = peak(out)
+	}
+	require.InDelta(t, 8, levels[0x77]/levels[0x00], 0.01)
+}
+
+func TestMixerHighPass(t *testing.T) {
+	var out collect
+	a := New(WithSink(&out))
+	a.WriteByte(0xFF24, 0x77)
+	a.WriteByte(0xFF25, 0xFF)
+	// a DAC that's on but silent outputs a constant offset, which the
+	// filter removes
+	a.WriteByte(0xFF12, 0x08)
+	a.Step(clockSpeed)
+	a.Flush()
+
+	require.NotZero(t, out[0].L)
+	require.InDelta(t, 0, out[len(out)-1].L, 0.001)
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(4)
+	r.WriteSamples([]Sample{{L: 1}, {L: 2}, {L: 3}})
+	require.Equal(t, 3, r.Len())
+
+	p := make([]Sample, 2)
+	require.Equal(t, 2, r.Read(p))
+	require.Equal(t, []Sample{{L: 1}, {L: 2}}, p)
+
+	// overflowing drops the oldest samples
+	r.WriteSamples([]Sample{{L: 4}, {L: 5}, {L: 6}, {L: 7}, {L: 8}})
+	require.Equal(t, 4, r.Len())
+	p = make([]Sample, 8)
+	require.Equal(t, 4, r.Read(p))
+	require.Equal(t, []Sample{{L: 5}, {L: 6}, {L: 7}, {L: 8}}, p[:4])
+	require.Zero(t, r.Read(p))
+}
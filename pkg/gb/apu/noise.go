@@ -0,0 +1,83 @@
+package apu
+
+// noiseDivisors are the base periods selected by bits 0-2 of NR43
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// noise is channel 4, which outputs the low bit of a linear feedback shift
+// register
+type noise struct {
+	NR      [5]byte // NR41-NR44 as last written at 1-4, there's no NR40
+	Enabled bool
+	Length  length
+	Env     envelope
+	Timer   int // cycles until the next shift
+	LFSR    uint16
+}
+
+// period is the number of cycles between LFSR shifts, the divisor shifted
+// left by the clock shift in the upper nibble of NR43
+func (c *noise) period() int {
+	return noiseDivisors[c.NR[3]&7] << (c.NR[3] >> 4)
+}
+
+func (c *noise) dac() bool {
+	return c.NR[2]&0xF8 != 0
+}
+
+func (c *noise) step(cycles int) {
+	if !c.Enabled {
+		return
+	}
+	c.Timer -= cycles
+	for c.Timer <= 0 {
+		c.Timer += c.period()
+		// clock shifts of 14 and 15 stop the LFSR
+		if c.NR[3]>>4 < 14 {
+			c.shift()
+		}
+	}
+}
+
+// shift feeds the xor of the two low bits back into bit 14, and also into
+// bit 6 in 7 bit mode
+func (c *noise) shift() {
+	x := (c.LFSR ^ c.LFSR>>1) & 1
+	c.LFSR = c.LFSR>>1 | x<<14
+	if c.NR[3]&0x08 != 0 {
+		c.LFSR = c.LFSR&^(1<<6) | x<<6
+	}
+}
+
+func (c *noise) output() byte {
+	if !c.Enabled || c.LFSR&1 == 1 {
+		return 0
+	}
+	return c.Env.Volume
+}
+
+func (c *noise) write(reg int, b byte, extraClock bool) {
+	c.NR[reg] = b
+	switch reg {
+	case 1:
+		c.Length.Counter = 64 - int(b&0x3F)
+	case 2:
+		if !c.dac() {
+			c.Enabled = false
+		}
+	case 4:
+		trigger := b&0x80 != 0
+		if c.Length.write(b&0x40 != 0, trigger, 64, extraClock) {
+			c.Enabled = false
+		}
+		if trigger {
+			c.trigger()
+		}
+	}
+}
+
+func (c *noise) trigger() {
+	c.Enabled = c.dac()
+	c.Timer = c.period()
+	c.LFSR = 0x7FFF
+	c.Env.trigger(c.NR[2])
+}
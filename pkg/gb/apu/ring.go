@@ -0,0 +1,57 @@
+package apu
+
+import "sync"
+
+// Ring is an AudioSink that buffers samples for a reader on another
+// goroutine, such as an audio device callback. Once it's full the oldest
+// samples are overwritten so the emulation never blocks on the reader.
+type Ring struct {
+	mu  sync.Mutex
+	buf []Sample
+	idx int // next sample to read
+	n   int // buffered samples
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{buf: make([]Sample, size)}
+}
+
+func (r *Ring) WriteSamples(s []Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range s {
+		r.buf[(r.idx+r.n)%len(r.buf)] = v
+		if r.n < len(r.buf) {
+			r.n++
+		} else {
+			r.idx = (r.idx + 1) % len(r.buf)
+		}
+	}
+}
+
+// Read copies up to len(p) of the oldest buffered samples into p and returns
+// how many it copied
+func (r *Ring) Read(p []Sample) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) && r.n > 0 {
+		p[n] = r.buf[r.idx]
+		r.idx = (r.idx + 1) % len(r.buf)
+		r.n--
+		n++
+	}
+	return n
+}
+
+// Len is the number of buffered samples
+func (r *Ring) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+// Cap is the number of samples the ring holds before overwriting
+func (r *Ring) Cap() int {
+	return len(r.buf)
+}
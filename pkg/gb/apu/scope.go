@@ -0,0 +1,157 @@
+package apu
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+const (
+	// scopeLen levels are kept per channel, one every scopePeriod cycles,
+	// which is about a frame of history
+	scopeLen    = 1024
+	scopePeriod = 64
+
+	scopeWidth  = 256
+	scopeHeight = 32 // per channel
+)
+
+var scopeColors = [4]color.RGBA{
+	{0xFF, 0x60, 0x60, 0xFF},
+	{0x60, 0xFF, 0x60, 0xFF},
+	{0x60, 0xA0, 0xFF, 0xFF},
+	{0xFF, 0xE0, 0x60, 0xFF},
+}
+
+// scope keeps the recent output of each channel for the debug view
+type scope struct {
+	levels [4][scopeLen]float32
+	idx    int // next level to write, the oldest one
+	timer  int
+}
+
+func (s *scope) capture(cycles int, levels func() [4]float64) {
+	s.timer += cycles
+	if s.timer < scopePeriod {
+		return
+	}
+	l := levels()
+	for ; s.timer >= scopePeriod; s.timer -= scopePeriod {
+		for ch, v := range l {
+			s.levels[ch][s.idx] = float32(v)
+		}
+		s.idx = (s.idx + 1) % scopeLen
+	}
+}
+
+// trace returns the last n levels of channel ch, oldest first, starting at a
+// rising edge when there is one so that periodic waves stand still
+func (s *scope) trace(ch, n int) []float32 {
+	all := make([]float32, 0, scopeLen)
+	all = append(all, s.levels[ch][s.idx:]...)
+	all = append(all, s.levels[ch][:s.idx]...)
+
+	lo, hi := all[0], all[0]
+	for _, v := range all {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	mid := (lo + hi) / 2
+	start := scopeLen - n
+	for i := 1; i < scopeLen-n; i++ {
+		if all[i-1] < mid && all[i] >= mid {
+			start = i
+			break
+		}
+	}
+	return all[start : start+n]
+}
+
+// Oscilloscope draws the recent output of the four channels as stacked
+// traces, muted channels are dimmed. It must be called from the emulation
+// goroutine, e.g. as a GPU overlay.
+func (a *APU) Oscilloscope() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, scopeWidth, scopeHeight*4))
+	for ch := 0; ch < 4; ch++ {
+		c := scopeColors[ch]
+		if a.mix.muted[ch] {
+			c = color.RGBA{c.R / 3, c.G / 3, c.B / 3, 0xFF}
+		}
+		top := ch * scopeHeight
+		for x := 0; x < scopeWidth; x++ {
+			img.SetRGBA(x, top+scopeHeight/2, color.RGBA{0x30, 0x30, 0x30, 0xFF})
+		}
+
+		// two levels per pixel, joined by vertical lines
+		levels := a.mix.scope.trace(ch, scopeWidth*2)
+		prev := -1
+		for x := 0; x < scopeWidth; x++ {
+			y := top + scopeHeight/2 - int(levels[x*2]*(scopeHeight/2-1))
+			from, to := y, y
+			if prev >= 0 {
+				if prev < from {
+					from = prev
+				}
+				if prev > to {
+					to = prev
+				}
+			}
+			for yy := from; yy <= to; yy++ {
+				img.SetRGBA(x, yy, c)
+			}
+			prev = y
+		}
+	}
+	return img
+}
+
+// Channel is a readout of a channel's current state
+type Channel struct {
+	Enabled bool
+	Muted   bool
+	Freq    float64 // Hz, of the tone for channels 1-3 and LFSR shifts for 4
+	Volume  byte    // envelope volume 0-15, or the output level shift for 3
+}
+
+// Channels returns a readout of the four channels
+func (a *APU) Channels() [4]Channel {
+	ch := [4]Channel{
+		{Enabled: a.ch1.Enabled, Freq: 131072 / float64(2048-int(a.ch1.freq())), Volume: a.ch1.Env.Volume},
+		{Enabled: a.ch2.Enabled, Freq: 131072 / float64(2048-int(a.ch2.freq())), Volume: a.ch2.Env.Volume},
+		{Enabled: a.ch3.Enabled, Freq: 65536 / float64(2048-int(a.ch3.freq())), Volume: a.ch3.NR[2] >> 5 & 3},
+		{Enabled: a.ch4.Enabled, Freq: clockSpeed / float64(a.ch4.period()), Volume: a.ch4.Env.Volume},
+	}
+	for i := range ch {
+		ch[i].Muted = a.mix.muted[i]
+	}
+	return ch
+}
+
+func (a *APU) String() string {
+	var b strings.Builder
+	for i, ch := range a.Channels() {
+		fmt.Fprintf(&b, "\tch%d: ", i+1)
+		if !ch.Enabled {
+			b.WriteString("off")
+		} else {
+			fmt.Fprintf(&b, "%7.1f Hz vol %2d", ch.Freq, ch.Volume)
+		}
+		if i == 0 && ch.Enabled && a.sweepTime() != 0 {
+			sign := "+"
+			if a.sweepMode() {
+				sign = "-"
+			}
+			fmt.Fprintf(&b, " sweep %d/128s %s%d", a.sweepTime(), sign, a.sweepShift())
+		}
+		if ch.Muted {
+			b.WriteString(" (muted)")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package apu
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelMute(t *testing.T) {
+	var out collect
+	a := New(WithSink(&out))
+	a.WriteByte(0xFF24, 0x77)
+	a.WriteByte(0xFF25, 0xFF)
+	playSquare(a)
+	a.SetMuted(2, true)
+	require.True(t, a.Muted(2))
+	a.Step(clockSpeed / 10)
+	a.Flush()
+	l, r := peak(out)
+	require.Zero(t, l)
+	require.Zero(t, r)
+
+	// muting is a host setting that survives the APU powering off
+	a.WriteByte(0xFF26, 0x00)
+	a.WriteByte(0xFF26, 0x80)
+	require.True(t, a.Muted(2))
+	require.Contains(t, a.String(), "ch2: off (muted)")
+}
+
+func TestChannelReadout(t *testing.T) {
+	a := New()
+	playSquare(a)
+	ch := a.Channels()
+	require.True(t, ch[1].Enabled)
+	require.Equal(t, 512.0, ch[1].Freq)
+	require.EqualValues(t, 15, ch[1].Volume)
+	require.False(t, ch[0].Enabled)
+	require.Contains(t, a.String(), "ch2:   512.0 Hz vol 15\n")
+}
+
+func TestOscilloscope(t *testing.T) {
+	a := New()
+	playSquare(a)
+	for i := 0; i < scopeLen*scopePeriod/4; i++ {
+		a.Step(4)
+	}
+
+	img := a.Oscilloscope()
+	require.Equal(t, scopeWidth, img.Bounds().Dx())
+	traced := func(ch int) bool {
+		for y := ch * scopeHeight; y < (ch+1)*scopeHeight; y++ {
+			for x := 0; x < scopeWidth; x++ {
+				if img.RGBAAt(x, y) == scopeColors[ch] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	require.True(t, traced(1))
+
+	// a square wave spends time at both extremes
+	top, bottom := false, false
+	for x := 0; x < scopeWidth; x++ {
+		top = top || img.RGBAAt(x, scopeHeight+1) == scopeColors[1]
+		bottom = bottom || img.RGBAAt(x, 2*scopeHeight-1) == scopeColors[1]
+	}
+	require.True(t, top)
+	require.True(t, bottom)
+	require.NotEqual(t, color.RGBA{}, img.RGBAAt(0, scopeHeight/2), "center line")
+}
@@ -0,0 +1,155 @@
+package apu
+
+// dutyPatterns are the waveforms selected by bits 6-7 of NRx1
+var dutyPatterns = [4][8]byte{
+	{0, 0, 0, 0, 0, 0, 0, 1}, // 12.5%
+	{1, 0, 0, 0, 0, 0, 0, 1}, // 25%
+	{1, 0, 0, 0, 0, 1, 1, 1}, // 50%
+	{0, 1, 1, 1, 1, 1, 1, 0}, // 75%
+}
+
+// square is a pulse channel. Channel 1 additionally sweeps its frequency,
+// channel 2 has no NR20 and never uses Sweep.
+type square struct {
+	NR       [5]byte // NRx0-NRx4 as last written
+	Enabled  bool
+	Length   length
+	Env      envelope
+	Sweep    sweep
+	Timer    int // cycles until the next duty step
+	DutyStep byte
+}
+
+// sweep periodically recalculates channel 1's frequency from a shadow copy
+type sweep struct {
+	Enabled bool
+	Shadow  uint16
+	Timer   byte
+	Negated bool // a subtraction was calculated since the last trigger
+}
+
+func (c *square) freq() uint16 {
+	return uint16(c.NR[3]) | uint16(c.NR[4]&7)<<8
+}
+
+func (c *square) setFreq(f uint16) {
+	c.NR[3] = byte(f)
+	c.NR[4] = c.NR[4]&^7 | byte(f>>8)&7
+}
+
+// period is the number of cycles between duty steps
+func (c *square) period() int {
+	return (2048 - int(c.freq())) * 4
+}
+
+// dac reports whether the channel's DAC is powered, which takes any of the
+// upper five bits of NRx2
+func (c *square) dac() bool {
+	return c.NR[2]&0xF8 != 0
+}
+
+func (c *square) step(cycles int) {
+	c.Timer -= cycles
+	for c.Timer <= 0 {
+		c.Timer += c.period()
+		c.DutyStep = (c.DutyStep + 1) & 7
+	}
+}
+
+// output is the channel's current 4 bit sample
+func (c *square) output() byte {
+	if !c.Enabled {
+		return 0
+	}
+	return dutyPatterns[c.NR[1]>>6][c.DutyStep] * c.Env.Volume
+}
+
+// write stores NRx0-NRx4, extraClock tells whether the frame sequencer's next
+// step skips length clocks
+func (c *square) write(reg int, b byte, extraClock bool) {
+	c.NR[reg] = b
+	switch reg {
+	case 0:
+		// leaving subtraction mode after a subtraction was used disables
+		// the channel
+		if c.Sweep.Negated && b&0x08 == 0 {
+			c.Enabled = false
+		}
+	case 1:
+		c.Length.Counter = 64 - int(b&0x3F)
+	case 2:
+		if !c.dac() {
+			c.Enabled = false
+		}
+	case 4:
+		trigger := b&0x80 != 0
+		if c.Length.write(b&0x40 != 0, trigger, 64, extraClock) {
+			c.Enabled = false
+		}
+		if trigger {
+			c.trigger()
+		}
+	}
+}
+
+func (c *square) trigger() {
+	c.Enabled = c.dac()
+	c.Timer = c.period()
+	c.Env.trigger(c.NR[2])
+}
+
+// triggerSweep restarts channel 1's sweep, an immediate overflow disables the
+// channel
+func (c *square) triggerSweep() {
+	period, shift := c.NR[0]>>4&7, c.NR[0]&7
+	c.Sweep.Shadow = c.freq()
+	c.Sweep.Timer = sweepTimer(period)
+	c.Sweep.Enabled = period != 0 || shift != 0
+	c.Sweep.Negated = false
+	if shift != 0 {
+		c.sweepCalc()
+	}
+}
+
+func (c *square) clockSweep() {
+	if c.Sweep.Timer > 0 {
+		c.Sweep.Timer--
+	}
+	if c.Sweep.Timer > 0 {
+		return
+	}
+
+	period, shift := c.NR[0]>>4&7, c.NR[0]&7
+	c.Sweep.Timer = sweepTimer(period)
+	if !c.Sweep.Enabled || period == 0 {
+		return
+	}
+	if f := c.sweepCalc(); f <= 2047 && shift != 0 {
+		c.Sweep.Shadow = f
+		c.setFreq(f)
+		// the new frequency is checked for overflow straight away
+		c.sweepCalc()
+	}
+}
+
+// sweepCalc returns the next frequency, disabling the channel on overflow
+func (c *square) sweepCalc() uint16 {
+	delta := c.Sweep.Shadow >> (c.NR[0] & 7)
+	f := c.Sweep.Shadow + delta
+	if c.NR[0]&0x08 != 0 {
+		f = c.Sweep.Shadow - delta
+		c.Sweep.Negated = true
+	}
+	if f > 2047 {
+		c.Enabled = false
+	}
+	return f
+}
+
+// sweepTimer treats a period of 0 as 8
+func sweepTimer(period byte) byte {
+	if period == 0 {
+		return 8
+	}
+	return period
+}
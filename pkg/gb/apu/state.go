@@ -0,0 +1,43 @@
+package apu
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// state mirrors the sound registers and channel timers for save states
+type state struct {
+	Ch1, Ch2          square
+	Ch3               wave
+	Ch4               noise
+	NR50, NR51        byte
+	Power             bool
+	SeqTimer, SeqStep int
+}
+
+func (a *APU) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(state{
+		Ch1: a.ch1, Ch2: a.ch2,
+		Ch3:  a.ch3,
+		Ch4:  a.ch4,
+		NR50: a.nr50, NR51: a.nr51,
+		Power:    a.power,
+		SeqTimer: a.seqTimer, SeqStep: a.seqStep,
+	})
+	return buf.Bytes(), err
+}
+
+func (a *APU) UnmarshalBinary(data []byte) error {
+	var s state
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	a.ch1, a.ch2 = s.Ch1, s.Ch2
+	a.ch3 = s.Ch3
+	a.ch4 = s.Ch4
+	a.nr50, a.nr51 = s.NR50, s.NR51
+	a.power = s.Power
+	a.seqTimer, a.seqStep = s.SeqTimer, s.SeqStep
+	return nil
+}
@@ -0,0 +1,130 @@
+package apu
+
+// wave is channel 3, which plays 32 4-bit samples from wave RAM
+type wave struct {
+	NR       [5]byte // NR30-NR34 as last written
+	RAM      [16]byte
+	Enabled  bool
+	Length   length
+	Timer    int  // cycles until the next sample
+	Position byte // index of the current sample, 0-31
+	Sample   byte // last sample byte read from wave RAM
+
+	// SinceRead counts the cycles since the channel last read wave RAM. On
+	// the DMG the CPU can only reach wave RAM in that same cycle while the
+	// channel plays, the CGB has no such restriction.
+	SinceRead int
+}
+
+func (c *wave) freq() uint16 {
+	return uint16(c.NR[3]) | uint16(c.NR[4]&7)<<8
+}
+
+// period is the number of cycles between samples
+func (c *wave) period() int {
+	return (2048 - int(c.freq())) * 2
+}
+
+func (c *wave) dac() bool {
+	return c.NR[0]&0x80 != 0
+}
+
+func (c *wave) step(cycles int) {
+	c.SinceRead += cycles
+	if !c.Enabled {
+		return
+	}
+	c.Timer -= cycles
+	for c.Timer <= 0 {
+		c.SinceRead = -c.Timer
+		c.Timer += c.period()
+		c.Position = (c.Position + 1) & 31
+		c.Sample = c.RAM[c.Position>>1]
+	}
+}
+
+// output is the current sample shifted by the output level in NR32
+func (c *wave) output() byte {
+	if !c.Enabled {
+		return 0
+	}
+	sample := c.Sample >> 4
+	if c.Position&1 == 1 {
+		sample = c.Sample & 0x0F
+	}
+	switch c.NR[2] >> 5 & 3 {
+	case 0:
+		return 0
+	case 1:
+		return sample
+	case 2:
+		return sample >> 1
+	default:
+		return sample >> 2
+	}
+}
+
+// write sets one of NR30-NR34, cgb turns off the DMG's wave RAM corruption
+// when retriggering
+func (c *wave) write(reg int, b byte, extraClock, cgb bool) {
+	c.NR[reg] = b
+	switch reg {
+	case 0:
+		if !c.dac() {
+			c.Enabled = false
+		}
+	case 1:
+		c.Length.Counter = 256 - int(b)
+	case 4:
+		trigger := b&0x80 != 0
+		if c.Length.write(b&0x40 != 0, trigger, 256, extraClock) {
+			c.Enabled = false
+		}
+		if trigger {
+			c.trigger(cgb)
+		}
+	}
+}
+
+func (c *wave) trigger(cgb bool) {
+	// retriggering on the DMG just as the channel reads a sample corrupts
+	// the first bytes of wave RAM with the ones being read. Emulation only
+	// has instruction granularity so this is approximated by the read
+	// being due within the next two cycles.
+	if !cgb && c.Enabled && c.Timer <= 2 {
+		pos := int((c.Position+1)&31) >> 1
+		if pos < 4 {
+			c.RAM[0] = c.RAM[pos]
+		} else {
+			copy(c.RAM[:4], c.RAM[pos&^3:pos&^3+4])
+		}
+	}
+
+	c.Enabled = c.dac()
+	c.Position = 0
+	// the first sample is delayed by a few cycles after triggering
+	c.Timer = c.period() + 6
+}
+
+// readRAM reads wave RAM on behalf of the CPU. While playing it reads the
+// byte the channel is on, the DMG only allows that in the cycle the channel
+// reads it while the CGB always does.
+func (c *wave) readRAM(i int, cgb bool) byte {
+	if !c.Enabled {
+		return c.RAM[i]
+	}
+	if cgb || c.SinceRead < 2 {
+		return c.RAM[c.Position>>1]
+	}
+	return 0xFF
+}
+
+// writeRAM writes wave RAM on behalf of the CPU, while playing the write
+// lands on the byte being read or, on the DMG, is lost
+func (c *wave) writeRAM(i int, b byte, cgb bool) {
+	if !c.Enabled {
+		c.RAM[i] = b
+	} else if cgb || c.SinceRead < 2 {
+		c.RAM[c.Position>>1] = b
+	}
+}
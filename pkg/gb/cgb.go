@@ -0,0 +1,101 @@
+package gb
+
+// IsCGB reports whether a cartridge asks for Game Boy Color mode, with 0x80
+// (enhanced) or 0xC0 (CGB only) in the header's CGB flag at 0x0143
+func IsCGB(rom []byte) bool {
+	return len(rom) > 0x143 && rom[0x143]&0x80 != 0
+}
+
+// cgbModule is implemented by modules with Game Boy Color features, the MMU
+// switches them on when the cartridge asks for CGB mode
+type cgbModule interface {
+	SetCGB(on bool)
+}
+
+// ioUnusedCGB overrides ioUnused for the registers that only exist, or have
+// more bits, in CGB mode
+var ioUnusedCGB = map[uint16]byte{
+	0xFF02: 0x7C, // SC, bit 1 selects the fast clock
+	0xFF4D: 0x7E, // KEY1
+	0xFF4F: 0xFE, // VBK
+	0xFF51: 0xFF, // HDMA1, write only
+	0xFF52: 0xFF, // HDMA2, write only
+	0xFF53: 0xFF, // HDMA3, write only
+	0xFF54: 0xFF, // HDMA4, write only
+	0xFF55: 0x00, // HDMA5
+	0xFF68: 0x40, // BCPS
+	0xFF69: 0x00, // BCPD
+	0xFF6A: 0x40, // OCPS
+	0xFF6B: 0x00, // OCPD
+	0xFF70: 0xF8, // SVBK
+}
+
+// ioMask is the unused bits of an I/O register in the current mode
+func (m *MMU) ioMask(a uint16) byte {
+	if m.cgb {
+		if mask, ok := ioUnusedCGB[a]; ok {
+			return mask
+		}
+	}
+	return ioUnused[a-0xFF00]
+}
+
+// ioConnected reports whether there is a register behind an I/O address in
+// the current mode
+func (m *MMU) ioConnected(a uint16) bool {
+	if m.cgb {
+		if _, ok := ioUnusedCGB[a]; ok {
+			return true
+		}
+	}
+	return ioConnected(a)
+}
+
+// CGB reports whether the machine runs in Game Boy Color mode
+func (m *MMU) CGB() bool {
+	return m.cgb
+}
+
+// wramBank is the WRAM bank mapped at 0xD000-0xDFFF, selected by SVBK in CGB
+// mode where bank 0 selects 1
+func (m *MMU) wramBank() int {
+	if b := int(m.svbk & 7); b > 0 {
+		return b
+	}
+	return 1
+}
+
+// wramIndex maps an address in 0xC000-0xDFFF to the banked WRAM
+func (m *MMU) wramIndex(a uint16) int {
+	i := int(a - 0xC000)
+	if i >= 0x1000 {
+		i += (m.wramBank() - 1) * 0x1000
+	}
+	return i
+}
+
+// DoubleSpeed reports whether a CGB has switched the CPU to its 8 MHz mode
+func (c *CPU) DoubleSpeed() bool {
+	return c.MMU.doubleSpeed
+}
+
+// cycleLength is the number of T cycles, at the normal speed rate every other
+// part of the machine runs at, that one CPU cycle takes
+func (c *CPU) cycleLength() int {
+	if c.MMU.doubleSpeed {
+		return 2
+	}
+	return 4
+}
+
+// stop is STOP. With a speed switch prepared in KEY1 it toggles double speed,
+// otherwise the CPU sleeps until a button is pressed.
+func stop(c *CPU) {
+	c.readByte() // STOP is followed by a padding byte
+	if c.MMU.cgb && c.MMU.key1&1 != 0 {
+		c.MMU.key1 &^= 1
+		c.MMU.doubleSpeed = !c.MMU.doubleSpeed
+		return
+	}
+	c.stopped = true
+}
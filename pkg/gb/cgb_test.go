@@ -0,0 +1,109 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCGB(program []byte) *Gameboy {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], program)
+	rom[0x143] = 0x80
+	return NewGameboy(nil, rom, gpu.New(), apu.New(), WithUnmappedPolicy(UnmappedStop))
+}
+
+func TestCGBBanking(t *testing.T) {
+	g := newTestCGB(nil)
+	mmu := g.CPU().MMU
+	require.True(t, mmu.CGB())
+	require.Equal(t, byte(0x11), g.CPU().R[A], "boot leaves A=0x11 on a CGB")
+
+	// vram
+	require.Equal(t, byte(0xFE), mmu.ReadByte(0xFF4F))
+	mmu.WriteByte(0x8000, 0x12)
+	mmu.WriteByte(0xFF4F, 0x01)
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF4F))
+	require.Zero(t, mmu.ReadByte(0x8000))
+	mmu.WriteByte(0x8000, 0x34)
+	mmu.WriteByte(0xFF4F, 0x00)
+	require.Equal(t, byte(0x12), mmu.ReadByte(0x8000))
+
+	// wram, bank 0 at 0xD000 selects bank 1
+	for bank := byte(0); bank < 8; bank++ {
+		mmu.WriteByte(0xFF70, bank)
+		mmu.WriteByte(0xD000, 0x10+bank)
+	}
+	mmu.WriteByte(0xC000, 0x99)
+	for bank := byte(2); bank < 8; bank++ {
+		mmu.WriteByte(0xFF70, bank)
+		require.Equal(t, 0xF8|bank, mmu.ReadByte(0xFF70))
+		require.Equal(t, 0x10+bank, mmu.ReadByte(0xD000))
+		require.Equal(t, 0x10+bank, mmu.ReadByte(0xF000), "echo")
+		require.Equal(t, byte(0x99), mmu.ReadByte(0xC000), "bank 0 is fixed")
+	}
+	mmu.WriteByte(0xFF70, 0x01)
+	require.Equal(t, byte(0x11), mmu.ReadByte(0xD000), "written through bank 0")
+	require.NoError(t, mmu.takeFault())
+}
+
+func TestDMGHasNoCGBRegisters(t *testing.T) {
+	g := newTestGameboy(nil, WithUnmappedPolicy(UnmappedStop))
+	mmu := g.CPU().MMU
+	require.False(t, mmu.CGB())
+	require.Equal(t, byte(0x01), g.CPU().R[A])
+
+	for _, a := range []uint16{0xFF4D, 0xFF4F, 0xFF70} {
+		mmu.WriteByte(a, 0x01)
+		require.Equal(t, byte(0xFF), mmu.ReadByte(a), "0x%04X", a)
+	}
+	mmu.WriteByte(0xD000, 0x12)
+	mmu.WriteByte(0xFF70, 0x02)
+	require.Equal(t, byte(0x12), mmu.ReadByte(0xD000))
+	require.NoError(t, mmu.takeFault())
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	g := newTestCGB([]byte{
+		0x3E, 0x01, // 0100 LD A, $01
+		0xE0, 0x4D, // 0102 LDH ($4D), A
+		0x10, 0x00, // 0104 STOP
+		0x00,       // 0106 NOP
+		0x00,       // 0107 NOP
+		0x10, 0x00, // 0108 STOP
+		0x00, // 010A NOP
+	})
+	cpu := g.CPU()
+	mmu := cpu.MMU
+
+	require.Equal(t, byte(0x7E), mmu.ReadByte(0xFF4D))
+	require.NoError(t, g.Step())
+	require.NoError(t, g.Step())
+	require.Equal(t, byte(0x7F), mmu.ReadByte(0xFF4D), "switch prepared")
+
+	require.NoError(t, g.Step())
+	require.True(t, cpu.DoubleSpeed())
+	require.Equal(t, byte(0xFE), mmu.ReadByte(0xFF4D))
+
+	// the CPU runs twice as fast against the rest of the machine
+	m, t0 := cpu.M, cpu.T
+	require.NoError(t, g.Step())
+	require.Equal(t, m+1, cpu.M)
+	require.Equal(t, t0+2, cpu.T)
+
+	// without a switch prepared STOP sleeps until a button is pressed
+	require.NoError(t, g.Step())
+	require.NoError(t, g.Step())
+	require.True(t, cpu.DoubleSpeed())
+	pc := cpu.PC
+	for i := 0; i < 10; i++ {
+		require.NoError(t, g.Step())
+	}
+	require.Equal(t, pc, cpu.PC)
+
+	mmu.SetButtons(ButtonA)
+	require.NoError(t, g.Step())
+	require.Equal(t, pc+1, cpu.PC)
+}
@@ -2,10 +2,9 @@ package gb
 
 import (
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/prestonp/gbc/pkg/logbuf"
 	"github.com/prestonp/gbc/pkg/shared"
@@ -61,7 +60,10 @@ type CPU struct {
 	PC uint16
 
 	M int // machine clock
-	T int // instruction clock
+	// T is the instruction clock in cycles of the normal speed 4 MHz clock
+	// that the LCD, sound and frame pacing run on. In CGB double speed mode
+	// a CPU cycle only takes 2 of them.
+	T int
 
 	MMU *MMU
 	GPU Module
@@ -72,7 +74,33 @@ type CPU struct {
 	shouldDI bool // disable interrupts
 	shouldEI bool // enable interrupts
 
-	log *logbuf.Buffer
+	log   *logbuf.Buffer
+	trace io.Writer // per instruction trace in the gameboy-doctor format
+
+	tasks chan func() // work queued by Do to run between instructions
+
+	frameHooks []func()
+
+	speed uint64 // float64 bits of the speed multiplier, see SetSpeed
+
+	fault   error // set by instructions that can't be executed
+	hung    bool  // locked up by an illegal opcode
+	stopped bool  // asleep after STOP until a button is pressed
+}
+
+// CyclesPerFrame is the number of T cycles the LCD takes to draw a frame,
+// 154 lines of 456 cycles each
+const CyclesPerFrame = 154 * 456
+
+// Frame returns the number of frames emulated so far
+func (c *CPU) Frame() int {
+	return c.T / CyclesPerFrame
+}
+
+// OnFrame registers fn to run on the emulation goroutine every time a frame
+// worth of cycles has been emulated
+func (c *CPU) OnFrame(fn func()) {
+	c.frameHooks = append(c.frameHooks, fn)
 }
 
 var (
@@ -90,8 +118,17 @@ func (c *CPU) Debugf(s string, args ...interface{}) {
 	fmt.Fprintf(c.log, "[debug] "+s, args...)
 }
 
-func NewCPU(mmu *MMU, gpu Module, debug bool) *CPU {
-	return &CPU{
+type Option func(c *CPU)
+
+// WithTrace writes the register state before every instruction to w
+func WithTrace(w io.Writer) Option {
+	return func(c *CPU) {
+		c.trace = w
+	}
+}
+
+func NewCPU(mmu *MMU, gpu Module, debug bool, opts ...Option) *CPU {
+	c := &CPU{
 		SP: 0x0,
 		PC: 0x0,
 
@@ -100,10 +137,36 @@ func NewCPU(mmu *MMU, gpu Module, debug bool) *CPU {
 		GPU:   gpu,
 		debug: debug,
 
-		log: logbuf.New(1024),
+		log:   logbuf.New(1024),
+		tasks: make(chan func(), 16),
+	}
+	c.SetSpeed(1)
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// SkipBoot sets up the machine the way the boot rom leaves it and starts at
+// the cartridge's entry point 0x0100. A tells games which model they run on.
+func (c *CPU) SkipBoot() {
+	c.MMU.booted = true
+	c.PC = 0x100
+	c.SP = 0xFFFE
+	c.R[A] = 0x01
+	if c.MMU.cgb {
+		c.R[A] = 0x11
 	}
 }
 
+// Hung reports whether an illegal opcode locked up the CPU, only a reset
+// recovers from that
+func (c *CPU) Hung() bool {
+	return c.hung
+}
+
 func (c *CPU) String() string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "State\n")
@@ -118,24 +181,48 @@ func (c *CPU) String() string {
 	fmt.Fprintf(&b, "H:\t0x%02X\n", c.R[H])
 	fmt.Fprintf(&b, "L:\t0x%02X\n", c.R[L])
 	fmt.Fprintf(&b, "IME:\t%v\n", c.IME)
+	if c.hung {
+		fmt.Fprintf(&b, "HUNG:\ttrue\n")
+	}
 	fmt.Fprintf(&b, "IE:\n%s", c.MMU.IE)
 	fmt.Fprintf(&b, "IF:\n%s", c.MMU.IF)
 	fmt.Fprintf(&b, "PPU:\n%s", c.MMU.gpu)
+	if c.MMU.apu != nil {
+		fmt.Fprintf(&b, "APU:\n%s", c.MMU.apu)
+	}
 	fmt.Fprintf(&b, "JOYP:\t%08b\n", c.MMU.joyp)
 	return b.String()
 }
 
+// Run emulates on its own goroutine, which owns the machine state, while the
+// GPU renders the frames it hands over. Once the window closes emulation is
+// stopped and Run only returns after the emulation goroutine has exited.
 func (c *CPU) Run() {
 	done := make(chan bool)
-	defer close(done)
+	stopped := make(chan bool)
+	defer func() {
+		close(done)
+		<-stopped
+	}()
 
 	go func() {
+		defer close(stopped)
+		pacer := newPacer()
 		for {
 			select {
 			case <-done:
 				return
+			case fn := <-c.tasks:
+				fn()
 			default:
-				c.Update()
+				frame := c.Frame()
+				if err := c.Update(); err != nil {
+					log.Printf("emulation stopped: %v", err)
+					return
+				}
+				if c.Frame() != frame {
+					pacer.frame(c.Speed())
+				}
 			}
 		}
 	}()
@@ -143,26 +230,102 @@ func (c *CPU) Run() {
 	c.GPU.Run(c)
 }
 
-func (c *CPU) Update() {
-	// todo remove artificial delay
-	time.Sleep(50 * time.Microsecond)
+// Do queues fn to run on the emulation goroutine between two instructions,
+// e.g. for save states triggered by the frontend. It doesn't block, the task
+// is dropped if the queue is full.
+func (c *CPU) Do(fn func()) {
+	select {
+	case c.tasks <- fn:
+	default:
+		log.Print("warning: cpu task queue full, dropping task")
+	}
+}
+
+// RunTasks runs the work queued by Do without waiting for more. Run does this
+// between instructions, frontends that step the CPU themselves such as the
+// debugger have to call it.
+func (c *CPU) RunTasks() {
+	for {
+		select {
+		case fn := <-c.tasks:
+			fn()
+		default:
+			return
+		}
+	}
+}
+
+// Update executes a single instruction and advances the other modules by the
+// cycles it took. It doesn't depend on wall clock time so the same inputs
+// always produce the same machine state.
+//
+// Unimplemented instructions return an *OpcodeError, and accesses to
+// unmapped memory an *AccessError if the unmapped policy is UnmappedStop.
+// Illegal opcodes hang the CPU like on hardware, afterwards Update only lets
+// time pass for the other modules. With UnmappedStop the illegal opcode
+// itself also returns an *OpcodeError wrapping ErrIllegalOpcode.
+func (c *CPU) Update() (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println(c.log.String())
-			fmt.Println(r)
-			os.Exit(1)
+			if c.debug {
+				fmt.Println(c.log.String())
+			}
+			err = fmt.Errorf("cpu fault at 0x%04X: %v", c.MMU.pc, r)
+		}
+		if err != nil {
+			c.flushTrace()
 		}
 	}()
 
-	c.resolveInterruptToggle()
-	op := c.fetch()
-	exec := c.decode(op)
-	exec(c)
-	c.Debugf("%s\n", c)
+	frame := c.Frame()
+	start := c.T
+	if c.stopped && c.MMU.buttons != 0 {
+		c.stopped = false
+	}
+	if c.hung || c.stopped {
+		c.M++
+		c.T += c.cycleLength()
+	} else {
+		c.resolveInterruptToggle()
+		c.writeTrace()
+		c.MMU.pc = c.PC
+		op := c.fetch()
+		exec := c.decode(op)
+		exec(c)
+		c.Debugf("%s\n", c)
+	}
+	if stall := c.MMU.takeStall(); stall > 0 {
+		// the CPU sits idle while DMA runs, in double speed it misses twice
+		// as many of its own cycles
+		c.M += stall / c.cycleLength()
+		c.T += stall
+	}
+
+	if c.GPU != nil {
+		c.GPU.Step(c.T - start)
+	}
+	if c.MMU.apu != nil {
+		c.MMU.apu.Step(c.T - start)
+	}
+	// the serial clock follows the CPU in double speed
+	serial := c.T - start
+	if c.MMU.doubleSpeed {
+		serial *= 2
+	}
+	c.MMU.stepSerial(serial)
 
-	if c.PC == 0x2817 {
-		panic("STOP LOADING TILE DATA")
+	if c.Frame() != frame {
+		for _, fn := range c.frameHooks {
+			fn()
+		}
 	}
+
+	if c.fault != nil {
+		err, c.fault = c.fault, nil
+		c.MMU.takeFault()
+		return err
+	}
+	return c.MMU.takeFault()
 }
 
 // See DI/EI opcode reference for more context, but basically the effects of EI/DI instructions are delayed by
@@ -180,13 +343,6 @@ func (c *CPU) resolveInterruptToggle() {
 func (c *CPU) fetch() byte {
 	op := c.readByte()
 	c.Debugf("fetched 0x%02X\n", op)
-
-	// todo: remove temporary breakpoint
-	// if op == 0xBE {
-	// 	fmt.Println("debugging breakpoint to let gpu render a frame")
-	// 	fmt.Println(c)
-	// 	time.Sleep(1 * time.Minute)
-	// }
 	return op
 }
 
@@ -222,6 +378,7 @@ var ops = map[byte]instruction{
 	0x0D: build(label("DEC C"), dec_reg(C)),
 	0x0E: build(label("LD C, d8"), ld_reg_d8(C)),
 
+	0x10: build(label("STOP"), stop),
 	0x11: build(label("LD DE, d16"), ld_word(D, E)),
 	0x13: build(label("INC DE"), inc_nn(D, E)),
 	0x15: build(label("DEC D"), dec_reg(D)),
@@ -309,6 +466,9 @@ func (c *CPU) decode(b byte) instruction {
 		return c.decodeExtended(c.readByte())
 	}
 
+	if illegalOps[b] {
+		return illegalInstruction(b)
+	}
 	if op, ok := ops[b]; !ok {
 		return instructionNotImplemented(b)
 	} else {
@@ -327,10 +487,11 @@ func (c *CPU) decodeExtended(b byte) instruction {
 
 // read a byte from the PC a.k.a `n`
 func (c *CPU) readByte() uint8 {
-	b := c.MMU.ReadByte(c.PC)
+	// instruction fetches don't count as data accesses for watchpoints
+	b := c.MMU.load(c.PC)
 	c.PC++
 	c.M++
-	c.T += 4
+	c.T += c.cycleLength()
 	return b
 }
 
@@ -342,9 +503,14 @@ func toWord(a, b uint8) uint16 {
 
 // Module represents another memory mapped module such as the GPU or APU
 type Module interface {
-	ReadByte(addr uint16) byte
-	WriteByte(addr uint16, b byte)
+	// ReadByte and WriteByte return shared.ErrUnmapped for addresses the
+	// module doesn't implement
+	ReadByte(addr uint16) (byte, error)
+	WriteByte(addr uint16, b byte) error
 	Run(debugger shared.Debugger)
+
+	// Step advances the module by a number of T cycles
+	Step(cycles int)
 }
 
 func (c *CPU) stackPush(b byte) {
@@ -357,3 +523,12 @@ func (c *CPU) stackPop() byte {
 	c.SP++
 	return b
 }
+
+// Call pushes PC and jumps to addr like a CALL instruction, so that a RET
+// comes back to the current PC. Players for rips such as GBS files use it to
+// run the music driver's routines.
+func (c *CPU) Call(addr uint16) {
+	c.stackPush(byte(c.PC & 0xFF))
+	c.stackPush(byte(c.PC >> 8))
+	c.PC = addr
+}
@@ -1,8 +1,11 @@
 package gb
 
 import (
+	"testing"
+
 	"github.com/prestonp/gbc/pkg/gb/apu"
 	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
 )
 
 func getTestCPU() *CPU {
@@ -11,3 +14,22 @@ func getTestCPU() *CPU {
 	mmu := NewMMU(nil, nil, gpu, apu)
 	return NewCPU(mmu, gpu, false)
 }
+
+func TestOnFrame(t *testing.T) {
+	cpu := getTestCPU()
+	cpu.MMU.rom = make([]byte, 0x8000)
+	cpu.MMU.booted = true
+
+	frames := 0
+	cpu.OnFrame(func() { frames++ })
+
+	// start just short of the boundaries, each NOP takes 4 cycles
+	for n := 1; n <= 3; n++ {
+		cpu.T = n*CyclesPerFrame - 8
+		cpu.Update()
+		require.Equal(t, n-1, frames)
+		cpu.Update()
+		require.Equal(t, n, frames)
+		require.Equal(t, n, cpu.Frame())
+	}
+}
@@ -0,0 +1,80 @@
+package gb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prestonp/gbc/pkg/shared"
+)
+
+var (
+	ErrUnimplementedOpcode = errors.New("unimplemented opcode")
+	ErrIllegalOpcode       = errors.New("illegal opcode")
+
+	// ErrUnmapped is returned for accesses to addresses that aren't backed by
+	// any hardware, or by hardware that isn't emulated yet
+	ErrUnmapped = shared.ErrUnmapped
+)
+
+// OpcodeError is returned when the CPU can't execute an instruction
+type OpcodeError struct {
+	PC       uint16
+	Op       byte
+	Extended bool // Op follows a 0xCB prefix
+	Err      error
+}
+
+func (e *OpcodeError) Error() string {
+	if e.Extended {
+		return fmt.Sprintf("%v 0xCB 0x%02X at 0x%04X", e.Err, e.Op, e.PC)
+	}
+	return fmt.Sprintf("%v 0x%02X at 0x%04X", e.Err, e.Op, e.PC)
+}
+
+func (e *OpcodeError) Unwrap() error {
+	return e.Err
+}
+
+// AccessError is returned for reads and writes the memory map can't serve
+type AccessError struct {
+	Addr  uint16
+	Write bool
+	Value byte // written value
+	PC    uint16
+	Err   error
+}
+
+func (e *AccessError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("%v: write 0x%04X = 0x%02X at 0x%04X", e.Err, e.Addr, e.Value, e.PC)
+	}
+	return fmt.Sprintf("%v: read 0x%04X at 0x%04X", e.Err, e.Addr, e.PC)
+}
+
+func (e *AccessError) Unwrap() error {
+	return e.Err
+}
+
+// UnmappedPolicy decides what happens when the program accesses an unmapped
+// address
+type UnmappedPolicy int
+
+const (
+	// UnmappedOpenBus reads 0xFF and ignores writes, logging a warning the
+	// first time each address is accessed. Illegal opcodes hang the CPU with
+	// a warning.
+	UnmappedOpenBus UnmappedPolicy = iota
+
+	// UnmappedStop fails the instruction with an *AccessError, and illegal
+	// opcodes with an *OpcodeError wrapping ErrIllegalOpcode. The CPU hangs
+	// all the same.
+	UnmappedStop
+)
+
+// WithUnmappedPolicy sets how accesses to unmapped addresses are handled, the
+// default is UnmappedOpenBus
+func WithUnmappedPolicy(p UnmappedPolicy) Option {
+	return func(c *CPU) {
+		c.MMU.policy = p
+	}
+}
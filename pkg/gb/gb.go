@@ -1,5 +1,28 @@
 package gb
 
+// Gameboy is a whole machine for embedding the emulator in other programs.
+// Faults such as unimplemented opcodes are returned from Step, see
+// OpcodeError and AccessError, instead of taking the process down.
 type Gameboy struct {
 	cpu *CPU
 }
+
+// NewGameboy wires a CPU to the memory map. Without a boot rom execution
+// starts at the cartridge's entry point 0x0100.
+func NewGameboy(bootRom, cartRom []byte, gpu, apu Module, opts ...Option) *Gameboy {
+	mmu := NewMMU(bootRom, cartRom, gpu, apu)
+	cpu := NewCPU(mmu, gpu, false, opts...)
+	if bootRom == nil {
+		cpu.SkipBoot()
+	}
+	return &Gameboy{cpu: cpu}
+}
+
+func (g *Gameboy) CPU() *CPU {
+	return g.cpu
+}
+
+// Step executes a single instruction
+func (g *Gameboy) Step() error {
+	return g.cpu.Update()
+}
@@ -0,0 +1,132 @@
+package gb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGameboy(program []byte, opts ...Option) *Gameboy {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], program)
+	return NewGameboy(nil, rom, gpu.New(), apu.New(), opts...)
+}
+
+func TestOpcodeErrors(t *testing.T) {
+	g := newTestGameboy([]byte{
+		0x00,             // 0100 NOP
+		0x08, 0xCB, 0x00, // 0101 LD ($00CB), SP, not implemented
+	}, WithUnmappedPolicy(UnmappedStop))
+
+	require.NoError(t, g.Step())
+	for i := 0; i < 2; i++ {
+		err := g.Step()
+		var opErr *OpcodeError
+		require.ErrorAs(t, err, &opErr)
+		require.ErrorIs(t, err, ErrUnimplementedOpcode)
+		require.Equal(t, OpcodeError{PC: 0x0101, Op: 0x08, Err: ErrUnimplementedOpcode}, *opErr)
+		require.Equal(t, uint16(0x0101), g.CPU().PC, "the operands aren't run as instructions")
+	}
+
+	g = newTestGameboy([]byte{
+		0xCB, 0x00, // 0100 RLC B, not implemented
+	}, WithUnmappedPolicy(UnmappedStop))
+	for i := 0; i < 2; i++ {
+		err := g.Step()
+		var opErr *OpcodeError
+		require.ErrorAs(t, err, &opErr)
+		require.Equal(t, OpcodeError{PC: 0x0100, Op: 0x00, Extended: true, Err: ErrUnimplementedOpcode}, *opErr)
+		require.EqualError(t, err, "unimplemented opcode 0xCB 0x00 at 0x0100")
+		require.Equal(t, uint16(0x0100), g.CPU().PC)
+	}
+
+	g = newTestGameboy([]byte{
+		0xD3, // 0100 illegal
+	}, WithUnmappedPolicy(UnmappedStop))
+	err := g.Step()
+	require.ErrorIs(t, err, ErrIllegalOpcode)
+	require.EqualError(t, err, "illegal opcode 0xD3 at 0x0100")
+	require.True(t, g.CPU().Hung())
+	require.NoError(t, g.Step(), "only the illegal opcode itself fails")
+	require.Equal(t, uint16(0x0100), g.CPU().PC)
+}
+
+func TestIllegalOpcodeHangs(t *testing.T) {
+	g := newTestGameboy([]byte{
+		0x00, // 0100 NOP
+		0xD3, // 0101 illegal
+		0x04, // 0102 INC B
+	})
+	cpu := g.CPU()
+
+	require.NoError(t, g.Step())
+	require.False(t, cpu.Hung())
+	require.NoError(t, g.Step())
+	require.True(t, cpu.Hung())
+
+	t0 := cpu.T
+	for i := 0; i < 10; i++ {
+		require.NoError(t, g.Step())
+	}
+	require.Equal(t, uint16(0x0101), cpu.PC)
+	require.Zero(t, cpu.R[B])
+	require.Equal(t, t0+40, cpu.T, "time keeps passing")
+}
+
+func TestIORegisterMasks(t *testing.T) {
+	g := newTestGameboy(nil, WithUnmappedPolicy(UnmappedStop))
+	mmu := g.CPU().MMU
+
+	for a := uint16(0xFF4C); a < 0xFF80; a++ {
+		require.Equal(t, byte(0xFF), mmu.ReadByte(a), "0x%04X", a)
+	}
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF03))
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF13), "write only")
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF50))
+
+	mmu.WriteByte(0xFF41, 0x00)
+	require.Equal(t, byte(0x80), mmu.ReadByte(0xFF41), "STAT bit 7")
+	mmu.WriteByte(0xFF0F, 0x01)
+	require.Equal(t, byte(0xE1), mmu.ReadByte(0xFF0F))
+	mmu.WriteByte(0xFF02, 0x81)
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF02))
+	mmu.WriteByte(0xFF26, 0x80)
+	require.Equal(t, byte(0xF0), mmu.ReadByte(0xFF26))
+	mmu.WriteByte(0xFF3F, 0x12)
+	require.Equal(t, byte(0x12), mmu.ReadByte(0xFF3F), "wave RAM")
+
+	// unconnected writes are ignored rather than treated as unmapped
+	mmu.WriteByte(0xFF7F, 0x12)
+	require.NoError(t, mmu.takeFault())
+	require.Equal(t, byte(0xFF), mmu.Peek(0xFF7F))
+}
+
+func TestUnmappedPolicy(t *testing.T) {
+	program := []byte{
+		0x3E, 0x12, // 0100 LD A, $12
+		0xE0, 0x05, // 0102 LDH ($05), A
+		0xF0, 0x05, // 0104 LDH A, ($05)
+	}
+
+	g := newTestGameboy(program)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, g.Step())
+	}
+	require.Equal(t, byte(0xFF), g.CPU().R[A], "open bus")
+
+	g = newTestGameboy(program, WithUnmappedPolicy(UnmappedStop))
+	require.NoError(t, g.Step())
+
+	err := g.Step()
+	var accessErr *AccessError
+	require.ErrorAs(t, err, &accessErr)
+	require.True(t, errors.Is(err, ErrUnmapped))
+	require.Equal(t, AccessError{Addr: 0xFF05, Write: true, Value: 0x12, PC: 0x0102, Err: ErrUnmapped}, *accessErr)
+
+	err = g.Step()
+	require.EqualError(t, err, "unmapped address: read 0xFF05 at 0x0104")
+	require.Equal(t, uint16(0x0106), g.CPU().PC, "the faulting instruction completes")
+}
@@ -0,0 +1,65 @@
+package gpu
+
+import (
+	"image"
+	"image/draw"
+)
+
+const (
+	screenWidth  = 160
+	screenHeight = 144
+)
+
+// present draws the finished frame into the back buffer and swaps it with the
+// front buffer the render loop reads. It runs on the emulation goroutine at
+// the start of vblank, so the picture is never torn by writes to vram
+// mid-frame.
+func (g *GPU) present() {
+	if g.back == nil {
+		g.back = image.NewRGBA(image.Rect(0, 0, screenWidth, screenHeight))
+	}
+	if g.lcdEnable {
+		draw.Draw(g.back, g.back.Bounds(), g, image.Point{}, draw.Src)
+	}
+
+	// the overlay is only built while there's a debug view to show it in
+	var overlay image.Image
+	if g.overlay != nil && g.debuggerShown() {
+		overlay = g.overlay()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.debugger != nil {
+		g.debugText = g.debugger.String()
+	}
+	g.overlayImg = overlay
+	g.lcdOn = g.lcdEnable
+	g.back, g.front = g.front, g.back
+	g.fresh = true
+}
+
+// takeOverlay returns the overlay captured with the last presented frame
+func (g *GPU) takeOverlay() image.Image {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.overlayImg
+}
+
+// takeFrame returns a copy of the front buffer if a new frame was presented
+// since the last call, and the debugger state captured with it. The copy is
+// nil while the LCD is off.
+func (g *GPU) takeFrame() (img *image.RGBA, debugText string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.fresh {
+		return nil, g.debugText, false
+	}
+	g.fresh = false
+	if !g.lcdOn {
+		return nil, g.debugText, true
+	}
+	img = image.NewRGBA(g.front.Rect)
+	copy(img.Pix, g.front.Pix)
+	return img, g.debugText, true
+}
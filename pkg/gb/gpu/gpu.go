@@ -5,8 +5,9 @@ import (
 	"image"
 	"image/color"
 	"log"
-	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
@@ -16,16 +17,35 @@ import (
 )
 
 type GPU struct {
-	showDebugger bool
-
-	vram []byte
+	showDebugger int32 // toggled by the render loop, read when presenting
+	closed       int32 // set by Close to stop the render loop
+	hotkeys      map[pixelgl.Button]func()
+	holdKeys     map[pixelgl.Button]func(held bool)
+
+	// frames are double buffered, the emulation goroutine draws into back
+	// and swaps it with front under mu, the render loop only reads front
+	mu         sync.Mutex
+	back       *image.RGBA
+	front      *image.RGBA
+	lcdOn      bool // whether front was drawn with the LCD on
+	fresh      bool // front hasn't been taken by the render loop yet
+	debugger   shared.Debugger
+	debugText  string
+	overlay    func() image.Image // drawn below the debugger text
+	overlayImg image.Image
+
+	cgb  bool   // Game Boy Color mode
+	vram []byte // two banks of 8 KiB, the second is only used in CGB mode
+	vbk  byte   // vram bank the CPU sees at 0x8000-0x9FFF
 	scx  byte
 	scy  byte
 	wy   byte // window y position
 	wx   byte // window x position
 	stat byte
 
-	ly byte // lcdc y-coordinate
+	ly     byte // lcdc y-coordinate
+	cycles int  // cycles spent on the current line
+	hblank func()
 
 	// lcd control
 	lcdEnable              bool
@@ -41,6 +61,11 @@ type GPU struct {
 	bgp  byte // bg palette
 	obp0 byte // obj palette 0
 	obp1 byte // obj palette 1
+
+	// CGB color palettes
+	bgPal        palette
+	objPal       palette
+	colorCorrect bool
 }
 
 func (g *GPU) String() string {
@@ -53,10 +78,10 @@ func (g *GPU) String() string {
 	return b.String()
 }
 
-func (g *GPU) WriteByte(a uint16, b byte) {
+func (g *GPU) WriteByte(a uint16, b byte) error {
 	switch {
 	case a >= 0x8000 && a <= 0x9FFF:
-		g.vram[a-0x8000] = b
+		g.vram[g.vramIndex(a)] = b
 	case a == 0xFF40:
 		// LCD Control
 		g.setControl(b)
@@ -78,43 +103,62 @@ func (g *GPU) WriteByte(a uint16, b byte) {
 		g.wy = b
 	case a == 0xFF4B:
 		g.wx = b
+	case a == 0xFF4F && g.cgb:
+		g.vbk = b & 1
+	case a == 0xFF68 && g.cgb:
+		g.bgPal.writeSpec(b)
+	case a == 0xFF69 && g.cgb:
+		g.bgPal.writeData(b)
+	case a == 0xFF6A && g.cgb:
+		g.objPal.writeSpec(b)
+	case a == 0xFF6B && g.cgb:
+		g.objPal.writeData(b)
 	case a >= 0xFE00 && a <= 0xFE9F:
 		g.oam[a-0xFE00] = b
 	default:
-		log.Panicf("unimplemented write gpu addr 0x%04X = 0x%02X\n", a, b)
+		return shared.ErrUnmapped
 	}
+	return nil
 }
 
-func (g *GPU) ReadByte(a uint16) byte {
+func (g *GPU) ReadByte(a uint16) (byte, error) {
 	switch {
 	case a >= 0x8000 && a <= 0x9FFF:
-		return g.vram[a-0x8000]
+		return g.vram[g.vramIndex(a)], nil
 	case a >= 0xFE00 && a <= 0xFE9F:
-		return g.oam[a-0xFE00]
+		return g.oam[a-0xFE00], nil
 	case a == 0xFF40:
-		return g.getControl()
+		return g.getControl(), nil
 	case a == 0xFF41:
-		return g.getStat()
+		return g.getStat(), nil
 	case a == 0xFF42:
-		return g.getScrollY()
+		return g.getScrollY(), nil
 	case a == 0xFF43:
-		return g.getScrollX()
+		return g.getScrollX(), nil
 	case a == 0xFF44:
-		return g.getLY()
+		return g.getLY(), nil
 	case a == 0xFF47:
-		return g.bgp
+		return g.bgp, nil
 	case a == 0xFF48:
-		return g.obp0
+		return g.obp0, nil
 	case a == 0xFF49:
-		return g.obp1
+		return g.obp1, nil
 	case a == 0xFF4A:
-		return g.wy
+		return g.wy, nil
 	case a == 0xFF4B:
-		return g.wx
-	default:
-		log.Panicf("unimplemented read gpu addr 0x%04X\n", a)
+		return g.wx, nil
+	case a == 0xFF4F && g.cgb:
+		return 0xFE | g.vbk, nil
+	case a == 0xFF68 && g.cgb:
+		return g.bgPal.readSpec(), nil
+	case a == 0xFF69 && g.cgb:
+		return g.bgPal.readData(), nil
+	case a == 0xFF6A && g.cgb:
+		return g.objPal.readSpec(), nil
+	case a == 0xFF6B && g.cgb:
+		return g.objPal.readData(), nil
 	}
-	panic("unexpected gpu failure")
+	return 0, shared.ErrUnmapped
 }
 
 func (g *GPU) getColor(idx byte) color.Color {
@@ -135,10 +179,24 @@ func (g *GPU) getColor(idx byte) color.Color {
 	}
 }
 
-func (g *GPU) getObjColor(idx byte) color.Color {
+// getBGColor is the color of a background pixel, from the CGB palette its
+// attributes select in CGB mode
+func (g *GPU) getBGColor(attr bgAttr, idx byte) color.Color {
+	if !g.cgb {
+		return g.getColor(idx)
+	}
+	return rgba(g.bgPal.color(attr.palette(), idx), g.colorCorrect)
+}
+
+// getObjColor is the color of an object pixel, attr is the object's OAM
+// flags which select the CGB palette in CGB mode
+func (g *GPU) getObjColor(attr, idx byte) color.Color {
 	if idx == 0 {
 		return color.Transparent
 	}
+	if g.cgb {
+		return rgba(g.objPal.color(attr&7, idx), g.colorCorrect)
+	}
 	return g.getColor(idx)
 }
 
@@ -146,13 +204,51 @@ type Option func(g *GPU)
 
 func WithDebugger(enable bool) Option {
 	return func(g *GPU) {
-		g.showDebugger = enable
+		g.setDebugger(enable)
+	}
+}
+
+// WithHotkey calls fn from the render loop whenever button is pressed
+func WithHotkey(button pixelgl.Button, fn func()) Option {
+	return func(g *GPU) {
+		if g.hotkeys == nil {
+			g.hotkeys = make(map[pixelgl.Button]func())
+		}
+		g.hotkeys[button] = fn
+	}
+}
+
+// WithHoldKey calls fn from the render loop when button is pressed and again
+// when it is released
+func WithHoldKey(button pixelgl.Button, fn func(held bool)) Option {
+	return func(g *GPU) {
+		if g.holdKeys == nil {
+			g.holdKeys = make(map[pixelgl.Button]func(held bool))
+		}
+		g.holdKeys[button] = fn
+	}
+}
+
+// WithOverlay shows the image returned by fn in the debug view, such as the
+// APU's oscilloscope. fn is called on the emulation goroutine whenever a
+// frame is presented while the debug view is shown.
+func WithOverlay(fn func() image.Image) Option {
+	return func(g *GPU) {
+		g.overlay = fn
+	}
+}
+
+// WithColorCorrection makes CGB colors look like they did on the CGB's LCD
+// instead of showing the raw RGB555 values at full saturation
+func WithColorCorrection(enable bool) Option {
+	return func(g *GPU) {
+		g.colorCorrect = enable
 	}
 }
 
 func New(opts ...Option) *GPU {
 	g := &GPU{
-		vram: make([]byte, 8*1024),
+		vram: make([]byte, 2*8*1024),
 		oam:  make([]byte, 40*4), // 40 sprites made of 4 bytes
 	}
 
@@ -163,6 +259,24 @@ func New(opts ...Option) *GPU {
 	return g
 }
 
+// SetCGB switches Game Boy Color features such as the second vram bank and
+// color palettes on. The background palettes start out white like the CGB
+// boot rom leaves them.
+func (g *GPU) SetCGB(on bool) {
+	g.cgb = on
+	if !on {
+		g.vbk = 0
+	}
+	for i := range g.bgPal.ram {
+		g.bgPal.ram[i] = 0xFF
+	}
+}
+
+// vramIndex maps a CPU address in 0x8000-0x9FFF to the selected vram bank
+func (g *GPU) vramIndex(a uint16) int {
+	return int(g.vbk)*0x2000 + int(a-0x8000)
+}
+
 func (g *GPU) setScrollX(x byte) {
 	g.scx = x
 }
@@ -188,6 +302,19 @@ func (g *GPU) getStat() byte {
 }
 
 func (g *GPU) setControl(b byte) {
+	wasEnabled := g.lcdEnable
+	defer func() {
+		// the screen blanks as soon as the LCD is switched off
+		if wasEnabled && !g.lcdEnable {
+			g.present()
+		}
+	}()
+	g.loadControl(b)
+}
+
+// loadControl sets the LCDC fields without the side effects of a write, for
+// restoring save states
+func (g *GPU) loadControl(b byte) {
 	g.lcdEnable = b&(1<<7) > 0
 	g.winTileMapArea = b&(1<<6) > 0
 	g.winEnable = b&(1<<5) > 0
@@ -232,15 +359,59 @@ func (g *GPU) resetLY() {
 }
 
 func (g *GPU) getLY() byte {
-	n := rand.Intn(2)
-	if n == 0 {
-		return 0x90
+	return g.ly
+}
+
+const (
+	cyclesPerLine = 456
+	linesPerFrame = 154
+	hblankStart   = 80 + 172 // OAM scan and drawing come before HBlank
+)
+
+// OnHBlank calls fn whenever a visible line enters HBlank
+func (g *GPU) OnHBlank(fn func()) {
+	g.hblank = fn
+}
+
+// Step advances the current line by the cycles the CPU spent. LY counts every
+// line including the 10 lines of vblank and stays at 0 while the LCD is off.
+func (g *GPU) Step(cycles int) {
+	if !g.lcdEnable {
+		g.ly = 0
+		g.cycles = 0
+		return
+	}
+
+	for cycles > 0 {
+		n := cyclesPerLine - g.cycles
+		if n > cycles {
+			n = cycles
+		}
+		before := g.cycles
+		g.cycles += n
+		cycles -= n
+
+		if g.hblank != nil && g.ly < screenHeight && before < hblankStart && g.cycles >= hblankStart {
+			g.hblank()
+		}
+		if g.cycles == cyclesPerLine {
+			g.cycles = 0
+			g.ly = (g.ly + 1) % linesPerFrame
+			if g.ly == screenHeight {
+				g.present()
+			}
+		}
 	}
-	return 0x94 // hack: 0x90 to unblock boot rom, 0x94 to unblock tetris, this is fixed when ly register is implemented
-	// return g.ly
 }
 
+// Run opens the window and renders frames until it is closed. The frames and
+// the debugger state are handed over by the emulation goroutine at vblank,
+// Run never touches the emulated hardware directly.
 func (g *GPU) Run(debugger shared.Debugger) {
+	g.mu.Lock()
+	g.debugger = debugger
+	g.mu.Unlock()
+
 	cfg := pixelgl.WindowConfig{
 		Title:  "gameboy",
 		Bounds: pixel.R(0, 0, 1024, 768),
@@ -252,29 +423,76 @@ func (g *GPU) Run(debugger shared.Debugger) {
 		panic(err)
 	}
 
-	for !win.Closed() {
+	var screen *pixel.Sprite
+	var overlay *pixel.Sprite
+	var debugText string
+	for !win.Closed() && atomic.LoadInt32(&g.closed) == 0 {
 		g.handleInput(win)
-		g.render(win, debugger)
+
+		if img, text, ok := g.takeFrame(); ok {
+			screen = nil
+			if img != nil {
+				pic := pixel.PictureDataFromImage(img)
+				screen = pixel.NewSprite(pic, pic.Bounds())
+			}
+			debugText = text
+			overlay = nil
+			if img := g.takeOverlay(); img != nil {
+				pic := pixel.PictureDataFromImage(img)
+				overlay = pixel.NewSprite(pic, pic.Bounds())
+			}
+		}
+
+		g.render(win, screen, debugText, overlay)
 		win.Update()
 	}
 }
 
+func (g *GPU) setDebugger(show bool) {
+	var v int32
+	if show {
+		v = 1
+	}
+	atomic.StoreInt32(&g.showDebugger, v)
+}
+
+func (g *GPU) debuggerShown() bool {
+	return atomic.LoadInt32(&g.showDebugger) == 1
+}
+
+// Close stops the render loop, causing Run to return
+func (g *GPU) Close() {
+	atomic.StoreInt32(&g.closed, 1)
+}
+
 func (g *GPU) handleInput(win *pixelgl.Window) {
 	if win.JustPressed(pixelgl.KeyGraveAccent) {
-		g.showDebugger = !g.showDebugger
+		g.setDebugger(!g.debuggerShown())
+	}
+	for button, fn := range g.hotkeys {
+		if win.JustPressed(button) {
+			fn()
+		}
+	}
+	for button, fn := range g.holdKeys {
+		if win.JustPressed(button) {
+			fn(true)
+		} else if win.JustReleased(button) {
+			fn(false)
+		}
 	}
 }
 
-func (g *GPU) render(win *pixelgl.Window, debugger shared.Debugger) {
+func (g *GPU) render(win *pixelgl.Window, screen *pixel.Sprite, debugText string, overlay *pixel.Sprite) {
 	win.Clear(color.Black)
-	g.renderBackground(win)
-	g.renderWindow(win)
-	g.renderSprites(win)
-	g.renderDebugger(win, debugger)
+	if screen != nil {
+		screen.Draw(win, pixel.IM.Moved(win.Bounds().Center()))
+	}
+	g.renderDebugger(win, debugText, overlay)
 }
 
-func (g *GPU) renderDebugger(win *pixelgl.Window, debugger shared.Debugger) {
-	if !g.showDebugger {
+func (g *GPU) renderDebugger(win *pixelgl.Window, debugText string, overlay *pixel.Sprite) {
+	if !g.debuggerShown() {
 		return
 	}
 
@@ -285,49 +503,40 @@ func (g *GPU) renderDebugger(win *pixelgl.Window, debugger shared.Debugger) {
 		Y: win.Bounds().Max.Y - padding,
 	}
 	txt := text.New(topLeft, basicAtlas)
-	fmt.Fprintln(txt, debugger.String())
+	fmt.Fprintln(txt, debugText)
 	txt.Draw(win, pixel.IM)
-}
 
-func (g *GPU) renderBackground(win *pixelgl.Window) {
-	if !g.lcdEnable {
-		return
+	if overlay != nil {
+		bottomRight := pixel.Vec{
+			X: win.Bounds().Max.X - padding - overlay.Frame().W()/2,
+			Y: win.Bounds().Min.Y + padding + overlay.Frame().H()/2,
+		}
+		overlay.Draw(win, pixel.IM.Moved(bottomRight))
 	}
-
-	pic := pixel.PictureDataFromImage(g)
-	sprite := pixel.NewSprite(pic, pic.Bounds())
-	sprite.Draw(win, pixel.IM.Moved(win.Bounds().Center()))
 }
 
-func (g *GPU) renderWindow(win *pixelgl.Window) {
-
-}
-
-func (g *GPU) renderSprites(win *pixelgl.Window) {
-
-}
-
-// read a tile into a byte slice storing the color IDs. The color IDs must
-// refer to palette to produce actual colors. The slice is flat, but is indexed
-// in row, col order.
-func (g *GPU) readTile(addrMode uint16, idx byte) []byte {
+// read a tile from a vram bank into a byte slice storing the color IDs. The
+// color IDs must refer to palette to produce actual colors. The slice is flat,
+// but is indexed in row, col order.
+func (g *GPU) readTile(bank int, addrMode uint16, idx byte) []byte {
+	// in 0x8800 mode tile ids are signed offsets from 0x9000
+	baseAddr := addrMode + uint16(idx)*16
 	if addrMode == 0x8800 {
-		log.Panicf("0x8800 addr mode not implemented")
+		baseAddr = uint16(0x9000 + int(int8(idx))*16)
 	}
 
-	baseAddr := addrMode + uint16(idx)*16
-
+	tile := g.vram[bank*0x2000:]
 	var b []byte
 
 	// read 16 bytes, each pair represents a line, refer to gb spec/docs for encoding
 	for row := uint16(0); row < 8; row++ {
-		lower := g.ReadByte(baseAddr + (row * 2))
-		upper := g.ReadByte(baseAddr + (row*2 + 1))
+		lower := tile[baseAddr+row*2-0x8000]
+		upper := tile[baseAddr+row*2+1-0x8000]
 
 		for col := 0; col < 8; col++ {
 			offset := 7 - col
 			mask := byte(1 << offset)
-			colorId := upper&mask>>(offset+1) | (lower&mask)>>offset
+			colorId := (upper&mask)>>offset<<1 | (lower&mask)>>offset
 			b = append(b, colorId)
 		}
 	}
@@ -337,9 +546,21 @@ func (g *GPU) readTile(addrMode uint16, idx byte) []byte {
 var _ image.Image = &GPU{}
 
 func (g *GPU) At(x, y int) color.Color {
-	if !g.bgAndWinEnablePriority {
+	colorID, attr, ok := g.bgPixel(x, y)
+	if !ok {
 		return color.White
 	}
+	return g.getBGColor(attr, colorID)
+}
+
+// bgPixel returns the color ID of the background at a screen position and
+// the attributes of its tile. ok is false if the background is off, in CGB
+// mode LCDC bit 0 only takes away the background's priority over objects so
+// it is always shown.
+func (g *GPU) bgPixel(x, y int) (colorID byte, attr bgAttr, ok bool) {
+	if !g.bgAndWinEnablePriority && !g.cgb {
+		return 0, 0, false
+	}
 
 	x += int(g.getScrollX())
 	y += int(g.getScrollY())
@@ -369,21 +590,44 @@ func (g *GPU) At(x, y int) color.Color {
 		tileMapOffset = 0x9C00
 	}
 	tileAddr := tileMapOffset + uint16(tileIdx)
-	tileID := g.ReadByte(tileAddr)
-	tileData := g.readTile(addrMode, tileID)
+	tileID := g.vram[tileAddr-0x8000]
+	if g.cgb {
+		// the attributes sit at the same address in bank 1
+		attr = bgAttr(g.vram[0x2000+tileAddr-0x8000])
+	}
+	tileData := g.readTile(attr.bank(), addrMode, tileID)
 
 	tileX := x % 8
 	tileY := y % 8
+	if attr.xflip() {
+		tileX = 7 - tileX
+	}
+	if attr.yflip() {
+		tileY = 7 - tileY
+	}
 
-	colorID := tileData[tileY*8+tileX]
+	return tileData[tileY*8+tileX], attr, true
+}
 
-	return g.getColor(colorID)
+// bgOverObj reports whether the background pixel hides objects, either
+// through its tile's priority attribute in CGB mode or an object's own
+// priority flag. Color 0 never hides objects and LCDC bit 0 overrides both
+// in CGB mode. Objects aren't drawn yet, this is the rule their renderer
+// follows together with getObjColor.
+func (g *GPU) bgOverObj(colorID byte, attr bgAttr, objBehind bool) bool {
+	if colorID == 0 {
+		return false
+	}
+	if g.cgb && !g.bgAndWinEnablePriority {
+		return false
+	}
+	return attr.priority() || objBehind
 }
 
 func (g *GPU) Bounds() image.Rectangle {
 	return image.Rectangle{
 		Min: image.Point{0, 0},
-		Max: image.Point{159, 143},
+		Max: image.Point{screenWidth, screenHeight},
 	}
 }
 
@@ -1,6 +1,7 @@
 package gpu
 
 import (
+	"image"
 	"image/color"
 	"testing"
 
@@ -21,3 +22,156 @@ func TestBGPalette(t *testing.T) {
 	require.EqualValues(t, color.RGBA{128, 128, 128, 255}, gpu.getColor(2))
 	require.EqualValues(t, color.White, gpu.getColor(3))
 }
+
+func TestFrameHandoff(t *testing.T) {
+	gpu := New()
+	for a := uint16(0x8000); a < 0x8010; a++ {
+		gpu.WriteByte(a, 0xFF)
+	}
+	gpu.WriteByte(0xFF47, 0xFC)
+	gpu.WriteByte(0xFF40, 0x91)
+
+	gpu.Step(143 * cyclesPerLine)
+	_, _, ok := gpu.takeFrame()
+	require.False(t, ok, "frames are presented at vblank")
+
+	gpu.Step(cyclesPerLine)
+	img, _, ok := gpu.takeFrame()
+	require.True(t, ok)
+	require.Equal(t, image.Rect(0, 0, screenWidth, screenHeight), img.Bounds())
+	require.EqualValues(t, color.RGBA{0, 0, 0, 255}, img.At(0, 0))
+	require.EqualValues(t, color.RGBA{0, 0, 0, 255}, img.At(screenWidth-1, screenHeight-1))
+
+	// vram written after vblank doesn't reach the frame already handed over
+	gpu.WriteByte(0xFF47, 0x00)
+	require.EqualValues(t, color.RGBA{0, 0, 0, 255}, img.At(0, 0))
+	_, _, ok = gpu.takeFrame()
+	require.False(t, ok, "each frame is only taken once")
+
+	gpu.Step(linesPerFrame * cyclesPerLine)
+	img, _, ok = gpu.takeFrame()
+	require.True(t, ok)
+	require.EqualValues(t, color.RGBA{255, 255, 255, 255}, img.At(0, 0))
+
+	gpu.WriteByte(0xFF40, 0x00)
+	img, _, ok = gpu.takeFrame()
+	require.True(t, ok, "switching the LCD off blanks the screen")
+	require.Nil(t, img)
+}
+
+func TestFrameHandoffConcurrent(t *testing.T) {
+	gpu := New()
+	gpu.WriteByte(0xFF40, 0x91)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for frame := 0; frame < 20; frame++ {
+			for line := 0; line < linesPerFrame; line++ {
+				gpu.WriteByte(0x8000, byte(line))
+				gpu.Step(cyclesPerLine)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			gpu.takeFrame()
+		}
+	}
+}
+
+func TestOnHBlank(t *testing.T) {
+	gpu := New()
+	var lines []byte
+	gpu.OnHBlank(func() {
+		lines = append(lines, gpu.ly)
+	})
+	gpu.WriteByte(0xFF40, 0x91)
+
+	gpu.Step(hblankStart - 1)
+	require.Empty(t, lines)
+	gpu.Step(1)
+	require.Equal(t, []byte{0}, lines)
+
+	// large steps don't skip any, vblank has none
+	gpu.Step(linesPerFrame*cyclesPerLine - hblankStart)
+	require.Len(t, lines, screenHeight)
+	require.Equal(t, byte(screenHeight-1), lines[len(lines)-1])
+}
+
+func TestSignedTileAddressing(t *testing.T) {
+	gpu := New()
+	gpu.WriteByte(0xFF47, 0xFC)
+	gpu.WriteByte(0xFF40, 0x81) // bg on, tile data at 0x8800
+
+	// tile ids are signed offsets from 0x9000
+	gpu.WriteByte(0x9000, 0xFF) // tile 0
+	gpu.WriteByte(0x8800, 0xFF) // tile -128
+	gpu.WriteByte(0x97F0, 0xFF) // tile 127
+	gpu.WriteByte(0x9800, 0x00)
+	gpu.WriteByte(0x9801, 0x80)
+	gpu.WriteByte(0x9802, 0x7F)
+	gpu.WriteByte(0x9803, 0x01)
+
+	require.EqualValues(t, color.Black, gpu.At(0, 0))
+	require.EqualValues(t, color.Black, gpu.At(8, 0))
+	require.EqualValues(t, color.Black, gpu.At(16, 0))
+	require.EqualValues(t, color.White, gpu.At(24, 0))
+	require.EqualValues(t, color.White, gpu.At(0, 1), "second row of the tile")
+}
+
+func TestTileBitplanes(t *testing.T) {
+	gpu := New()
+	gpu.WriteByte(0xFF47, 0xE4) // identity palette
+	gpu.WriteByte(0xFF40, 0x91)
+
+	// the first byte of a row holds the low bit of each color ID and the
+	// second the high bit
+	gpu.WriteByte(0x8000, 0x0F)
+	gpu.WriteByte(0x8001, 0x33)
+
+	shades := []color.Color{
+		color.White,
+		color.RGBA{128, 128, 128, 255},
+		color.RGBA{192, 192, 192, 255},
+		color.Black,
+	}
+	for x, id := range []int{0, 0, 2, 2, 1, 1, 3, 3} {
+		require.EqualValues(t, shades[id], gpu.At(x, 0), "pixel %d", x)
+	}
+}
+
+func TestOverlayOnlyWhileDebugging(t *testing.T) {
+	calls := 0
+	gpu := New(WithOverlay(func() image.Image {
+		calls++
+		return image.NewRGBA(image.Rect(0, 0, 1, 1))
+	}))
+	gpu.WriteByte(0xFF40, 0x91)
+
+	gpu.Step(linesPerFrame * cyclesPerLine)
+	require.Equal(t, 0, calls, "the overlay isn't built while the debug view is hidden")
+	require.Nil(t, gpu.takeOverlay())
+
+	gpu.setDebugger(true)
+	gpu.Step(linesPerFrame * cyclesPerLine)
+	require.Equal(t, 1, calls)
+	require.NotNil(t, gpu.takeOverlay())
+}
+
+func TestLoadStateDoesNotPresent(t *testing.T) {
+	off := New()
+	data, err := off.MarshalBinary()
+	require.NoError(t, err)
+
+	gpu := New()
+	gpu.WriteByte(0xFF40, 0x91)
+	require.NoError(t, gpu.UnmarshalBinary(data))
+	require.EqualValues(t, 0, gpu.getControl())
+	_, _, ok := gpu.takeFrame()
+	require.False(t, ok, "restoring LCDC doesn't hand over a frame")
+}
@@ -0,0 +1,66 @@
+package gpu
+
+import "image/color"
+
+// palette is one of the CGB's two color RAMs, 8 palettes of 4 RGB555 colors
+// for either the background or objects. The CPU accesses it through an index
+// register (BCPS/OCPS) and a data register (BCPD/OCPD).
+type palette struct {
+	ram  [64]byte
+	spec byte // bit 7 increments the index after data writes, bits 0-5 index ram
+}
+
+func (p *palette) readSpec() byte {
+	return p.spec | 0x40
+}
+
+func (p *palette) writeSpec(b byte) {
+	p.spec = b & 0xBF
+}
+
+func (p *palette) readData() byte {
+	return p.ram[p.spec&0x3F]
+}
+
+// writeData stores b at the index and advances it in auto increment mode, the
+// index wraps around within the 64 bytes. Reads never increment.
+func (p *palette) writeData(b byte) {
+	p.ram[p.spec&0x3F] = b
+	if p.spec&0x80 != 0 {
+		p.spec = 0x80 | (p.spec+1)&0x3F
+	}
+}
+
+// color looks up color idx of palette number pal as RGB555
+func (p *palette) color(pal, idx byte) uint16 {
+	i := int(pal&7)*8 + int(idx&3)*2
+	return uint16(p.ram[i]) | uint16(p.ram[i+1]&0x7F)<<8
+}
+
+// rgba converts an RGB555 color to RGBA. Without correction the 5 bit
+// channels are scaled to the full range. With correction they are mixed the
+// way the CGB's LCD blends them, which mutes the saturated colors games were
+// designed for.
+func rgba(c uint16, correct bool) color.RGBA {
+	r := int(c & 0x1F)
+	g := int(c >> 5 & 0x1F)
+	b := int(c >> 10 & 0x1F)
+	if !correct {
+		return color.RGBA{byte(r<<3 | r>>2), byte(g<<3 | g>>2), byte(b<<3 | b>>2), 255}
+	}
+	return color.RGBA{
+		R: byte((r*13 + g*2 + b) >> 1),
+		G: byte((g*3 + b) << 1),
+		B: byte((r*3 + g*2 + b*11) >> 1),
+		A: 255,
+	}
+}
+
+// bgAttr is a background map attribute byte from vram bank 1
+type bgAttr byte
+
+func (a bgAttr) palette() byte  { return byte(a) & 7 }
+func (a bgAttr) bank() int      { return int(a>>3) & 1 }
+func (a bgAttr) xflip() bool    { return a&(1<<5) != 0 }
+func (a bgAttr) yflip() bool    { return a&(1<<6) != 0 }
+func (a bgAttr) priority() bool { return a&(1<<7) != 0 }
@@ -0,0 +1,117 @@
+package gpu
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaletteAutoIncrement(t *testing.T) {
+	gpu := New()
+	gpu.SetCGB(true)
+
+	gpu.WriteByte(0xFF68, 0x80|0x3E)
+	require.Equal(t, byte(0xFE), mustRead(t, gpu, 0xFF68))
+	for _, b := range []byte{0x1F, 0x00, 0xE0, 0x03} {
+		gpu.WriteByte(0xFF69, b)
+	}
+	require.Equal(t, byte(0xC2), mustRead(t, gpu, 0xFF68), "the index wraps around")
+	require.Equal(t, byte(0x1F), gpu.bgPal.ram[0x3E])
+	require.Equal(t, byte(0x00), gpu.bgPal.ram[0x3F])
+	require.Equal(t, byte(0xE0), gpu.bgPal.ram[0x00])
+	gpu.WriteByte(0xFF68, 0x80)
+	require.Equal(t, byte(0xE0), mustRead(t, gpu, 0xFF69), "reads don't increment")
+	require.Equal(t, byte(0xE0), mustRead(t, gpu, 0xFF69))
+
+	// without auto increment the index stays put
+	gpu.WriteByte(0xFF6A, 0x05)
+	gpu.WriteByte(0xFF6B, 0x12)
+	gpu.WriteByte(0xFF6B, 0x34)
+	require.Equal(t, byte(0x45), mustRead(t, gpu, 0xFF6A))
+	require.Equal(t, byte(0x34), mustRead(t, gpu, 0xFF6B))
+	require.Equal(t, byte(0xFF), gpu.bgPal.ram[0x05], "separate color RAMs")
+}
+
+func TestRGB555(t *testing.T) {
+	require.Equal(t, color.RGBA{255, 0, 0, 255}, rgba(0x001F, false))
+	require.Equal(t, color.RGBA{0, 255, 0, 255}, rgba(0x03E0, false))
+	require.Equal(t, color.RGBA{0, 0, 255, 255}, rgba(0x7C00, false))
+	require.Equal(t, color.RGBA{255, 255, 255, 255}, rgba(0x7FFF, false))
+	require.Equal(t, color.RGBA{0, 0, 0, 255}, rgba(0x0000, true))
+
+	white := rgba(0x7FFF, true)
+	require.Equal(t, white.R, white.G)
+	require.Equal(t, white.G, white.B, "white stays neutral")
+	red := rgba(0x001F, true)
+	require.Less(t, red.R, byte(255))
+	require.NotZero(t, red.B, "colors bleed into each other")
+}
+
+func TestCGBBackground(t *testing.T) {
+	gpu := New()
+	gpu.SetCGB(true)
+
+	// palette 2 color 1 is red, color 2 blue
+	gpu.WriteByte(0xFF68, 0x80|2*8+2)
+	for _, b := range []byte{0x1F, 0x00, 0x00, 0x7C} {
+		gpu.WriteByte(0xFF69, b)
+	}
+
+	// tile 1 in bank 1 has color 1 in its top left pixel and color 2 in the
+	// bottom right one
+	gpu.WriteByte(0xFF4F, 1)
+	gpu.WriteByte(0x8010, 0x80)
+	gpu.WriteByte(0x801F, 0x01)
+	// map entry 0 uses it with palette 2
+	gpu.WriteByte(0x9800, 0x0A)
+	gpu.WriteByte(0xFF4F, 0)
+	gpu.WriteByte(0x9800, 0x01)
+	gpu.WriteByte(0xFF40, 0x91)
+
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	require.Equal(t, red, gpu.At(0, 0))
+	require.Equal(t, blue, gpu.At(7, 7))
+	require.Equal(t, color.RGBA{255, 255, 255, 255}, gpu.At(8, 0), "palettes start out white")
+
+	// flipped both ways
+	gpu.WriteByte(0xFF4F, 1)
+	gpu.WriteByte(0x9800, 0x0A|0x60)
+	require.Equal(t, blue, gpu.At(0, 0))
+	require.Equal(t, red, gpu.At(7, 7))
+
+	// LCDC bit 0 doesn't hide the background in CGB mode
+	gpu.WriteByte(0xFF40, 0x90)
+	require.Equal(t, blue, gpu.At(0, 0))
+}
+
+func TestBGPriority(t *testing.T) {
+	gpu := New()
+	gpu.SetCGB(true)
+	gpu.WriteByte(0xFF40, 0x91)
+
+	require.False(t, gpu.bgOverObj(1, 0, false))
+	require.True(t, gpu.bgOverObj(1, 0x80, false))
+	require.True(t, gpu.bgOverObj(1, 0, true))
+	require.False(t, gpu.bgOverObj(0, 0x80, true), "color 0 is always behind")
+
+	gpu.WriteByte(0xFF40, 0x90)
+	require.False(t, gpu.bgOverObj(1, 0x80, true), "LCDC bit 0 overrides priority")
+}
+
+func TestDMGIgnoresCGBRegisters(t *testing.T) {
+	gpu := New()
+	for _, a := range []uint16{0xFF4F, 0xFF68, 0xFF69, 0xFF6A, 0xFF6B} {
+		require.Error(t, gpu.WriteByte(a, 0))
+		_, err := gpu.ReadByte(a)
+		require.Error(t, err)
+	}
+}
+
+func mustRead(t *testing.T, gpu *GPU, a uint16) byte {
+	t.Helper()
+	b, err := gpu.ReadByte(a)
+	require.NoError(t, err)
+	return b
+}
@@ -0,0 +1,82 @@
+package gpu
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// state mirrors the GPU's registers and memory for save states
+type state struct {
+	VRAM   []byte
+	OAM    []byte
+	SCX    byte
+	SCY    byte
+	WY     byte
+	WX     byte
+	Stat   byte
+	LY     byte
+	Cycles int
+	LCDC   byte
+	BGP    byte
+	OBP0   byte
+	OBP1   byte
+	VBK    byte
+
+	BGPalette  []byte
+	BCPS       byte
+	OBJPalette []byte
+	OCPS       byte
+}
+
+func (g *GPU) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(state{
+		VRAM:   g.vram,
+		OAM:    g.oam,
+		SCX:    g.scx,
+		SCY:    g.scy,
+		WY:     g.wy,
+		WX:     g.wx,
+		Stat:   g.stat,
+		LY:     g.ly,
+		Cycles: g.cycles,
+		LCDC:   g.getControl(),
+		BGP:    g.bgp,
+		OBP0:   g.obp0,
+		OBP1:   g.obp1,
+		VBK:    g.vbk,
+
+		BGPalette:  g.bgPal.ram[:],
+		BCPS:       g.bgPal.spec,
+		OBJPalette: g.objPal.ram[:],
+		OCPS:       g.objPal.spec,
+	})
+	return buf.Bytes(), err
+}
+
+func (g *GPU) UnmarshalBinary(data []byte) error {
+	var s state
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	copy(g.vram, s.VRAM)
+	copy(g.oam, s.OAM)
+	g.scx = s.SCX
+	g.scy = s.SCY
+	g.wy = s.WY
+	g.wx = s.WX
+	g.stat = s.Stat
+	g.ly = s.LY
+	g.cycles = s.Cycles
+	// a write to LCDC could present a half restored frame
+	g.loadControl(s.LCDC)
+	g.bgp = s.BGP
+	g.obp0 = s.OBP0
+	g.obp1 = s.OBP1
+	g.vbk = s.VBK
+	copy(g.bgPal.ram[:], s.BGPalette)
+	g.bgPal.spec = s.BCPS
+	copy(g.objPal.ram[:], s.OBJPalette)
+	g.objPal.spec = s.OCPS
+	return nil
+}
@@ -0,0 +1,94 @@
+package gb
+
+// hdmaBlockCycles is how long the CPU is stalled per 16 byte block, the same
+// in both speed modes since the transfer runs on the LCD's clock
+const hdmaBlockCycles = 32
+
+// hblankModule is implemented by LCDs that report the start of each visible
+// line's HBlank, which drives HBlank DMA
+type hblankModule interface {
+	OnHBlank(fn func())
+}
+
+// hdma is the CGB's VRAM DMA controller, HDMA1-HDMA5 at 0xFF51-0xFF55. It
+// copies 16 byte blocks from ROM or RAM to the selected VRAM bank, either all
+// at once (general purpose DMA) or one block per HBlank.
+type hdma struct {
+	src    uint16
+	dst    uint16 // offset into vram
+	blocks int    // blocks left to copy
+	hblank bool   // an HBlank transfer is running
+}
+
+// readHDMA5 reports the blocks left minus one and whether an HBlank transfer
+// is running in bit 7, which reads as 0 while active. A finished transfer
+// reads 0xFF.
+func (m *MMU) readHDMA5() byte {
+	n := byte(m.hdma.blocks-1) & 0x7F
+	if m.hdma.hblank {
+		return n
+	}
+	return 0x80 | n
+}
+
+func (m *MMU) writeHDMA(a uint16, n byte) {
+	switch a {
+	case 0xFF51:
+		m.hdma.src = uint16(n)<<8 | m.hdma.src&0xFF
+	case 0xFF52:
+		m.hdma.src = m.hdma.src&0xFF00 | uint16(n&0xF0)
+	case 0xFF53:
+		m.hdma.dst = uint16(n&0x1F)<<8 | m.hdma.dst&0xFF
+	case 0xFF54:
+		m.hdma.dst = m.hdma.dst&0x1F00 | uint16(n&0xF0)
+	case 0xFF55:
+		if m.hdma.hblank && n&0x80 == 0 {
+			// cancel, the remaining length stays readable
+			m.hdma.hblank = false
+			return
+		}
+		m.hdma.blocks = int(n&0x7F) + 1
+		if n&0x80 != 0 {
+			m.hdma.hblank = true
+			return
+		}
+		// general purpose DMA stalls the CPU until everything is copied
+		for m.hdma.blocks > 0 {
+			m.hdmaBlock()
+		}
+	}
+}
+
+// hdmaHBlank copies the next block of an HBlank transfer
+func (m *MMU) hdmaHBlank() {
+	if !m.hdma.hblank {
+		return
+	}
+	m.hdmaBlock()
+	if m.hdma.blocks == 0 {
+		m.hdma.hblank = false
+	}
+}
+
+// hdmaBlock copies 16 bytes and charges the CPU for the time it takes. The
+// destination wraps around within VRAM.
+func (m *MMU) hdmaBlock() {
+	for i := 0; i < 16; i++ {
+		b, err := m.read(m.hdma.src)
+		if err != nil {
+			b = 0xFF
+		}
+		m.gpu.WriteByte(0x8000|m.hdma.dst, b)
+		m.hdma.src++
+		m.hdma.dst = (m.hdma.dst + 1) & 0x1FFF
+	}
+	m.hdma.blocks--
+	m.stall += hdmaBlockCycles
+}
+
+// takeStall returns the cycles DMA stalled the CPU for since the last call
+func (m *MMU) takeStall() int {
+	s := m.stall
+	m.stall = 0
+	return s
+}
@@ -0,0 +1,93 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneralPurposeDMA(t *testing.T) {
+	g := newTestCGB([]byte{
+		0x3E, 0x01, // 0100 LD A, $01
+		0xE0, 0x80, // 0102 LDH ($80), A
+		0xE0, 0x55, // 0104 LDH ($55), A
+	})
+	cpu := g.CPU()
+	mmu := cpu.MMU
+	for i := uint16(0); i < 32; i++ {
+		mmu.WriteByte(0xC100+i, byte(i+1))
+	}
+	mmu.WriteByte(0xFF4F, 1)
+	mmu.WriteByte(0xFF51, 0xC1)
+	mmu.WriteByte(0xFF52, 0x0F) // the low nibble is ignored
+	mmu.WriteByte(0xFF53, 0xE8) // so are the top 3 bits of the destination
+	mmu.WriteByte(0xFF54, 0x00)
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF51), "write only")
+
+	require.NoError(t, g.Step())
+	t0 := cpu.T
+	require.NoError(t, g.Step())
+	ldh := cpu.T - t0
+	require.NoError(t, g.Step())
+	require.Equal(t, t0+2*ldh+2*hdmaBlockCycles, cpu.T, "the CPU is stalled for the whole copy")
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF55), "done")
+
+	for i := uint16(0); i < 32; i++ {
+		require.Equal(t, byte(i+1), mmu.ReadByte(0x8800+i))
+	}
+	mmu.WriteByte(0xFF4F, 0)
+	require.Zero(t, mmu.ReadByte(0x8800), "copied to the selected bank")
+	require.NoError(t, mmu.takeFault())
+}
+
+func TestHBlankDMA(t *testing.T) {
+	g := newTestCGB([]byte{
+		0x18, 0xFE, // 0100 JR -2
+	})
+	cpu := g.CPU()
+	mmu := cpu.MMU
+	for i := uint16(0); i < 64; i++ {
+		mmu.WriteByte(0xC000+i, byte(i+1))
+	}
+	mmu.WriteByte(0xFF40, 0x91)
+	mmu.WriteByte(0xFF51, 0xC0)
+	mmu.WriteByte(0xFF52, 0x00)
+	mmu.WriteByte(0xFF53, 0x00)
+	mmu.WriteByte(0xFF54, 0x00)
+	mmu.WriteByte(0xFF55, 0x83)
+	require.Equal(t, byte(0x03), mmu.ReadByte(0xFF55), "active with 4 blocks left")
+
+	// one block per HBlank
+	for cpu.T < hblankCycles {
+		require.NoError(t, g.Step())
+	}
+	require.Equal(t, byte(0x02), mmu.ReadByte(0xFF55))
+	require.Equal(t, byte(16), mmu.ReadByte(0x800F))
+	require.Zero(t, mmu.ReadByte(0x8010))
+
+	// the CPU pays for the block with the next instruction
+	t0, m0 := cpu.T, cpu.M
+	require.NoError(t, g.Step())
+	require.Equal(t, t0+8+hdmaBlockCycles, cpu.T)
+	require.Equal(t, m0+2+hdmaBlockCycles/4, cpu.M)
+
+	for cpu.T < lineCycles+hblankCycles {
+		require.NoError(t, g.Step())
+	}
+	require.Equal(t, byte(0x01), mmu.ReadByte(0xFF55))
+	require.Equal(t, byte(32), mmu.ReadByte(0x801F))
+
+	// cancelling keeps the remaining length
+	mmu.WriteByte(0xFF55, 0x00)
+	require.Equal(t, byte(0x81), mmu.ReadByte(0xFF55))
+	for cpu.T < 3*lineCycles {
+		require.NoError(t, g.Step())
+	}
+	require.Zero(t, mmu.ReadByte(0x8020))
+	require.NoError(t, mmu.takeFault())
+}
+
+const (
+	lineCycles   = 456
+	hblankCycles = 80 + 172
+)
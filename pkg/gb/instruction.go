@@ -1,17 +1,36 @@
 package gb
 
-import "log"
-
 type instruction func(c *CPU)
 
+// illegalOps aren't defined by the instruction set, executing one locks up
+// the CPU
+var illegalOps = map[byte]bool{
+	0xD3: true, 0xDB: true, 0xDD: true, 0xE3: true, 0xE4: true, 0xEB: true,
+	0xEC: true, 0xED: true, 0xF4: true, 0xFC: true, 0xFD: true,
+}
+
+// instructionNotImplemented and extendedInstructionNotImplemented fail the
+// instruction and rewind to its opcode, so that every later step fails the
+// same way instead of running its operands as instructions
 func instructionNotImplemented(op byte) instruction {
 	return func(c *CPU) {
-		log.Panicf("unimplemented instruction 0x%02X", op)
+		c.PC = c.MMU.pc
+		c.fault = &OpcodeError{PC: c.MMU.pc, Op: op, Err: ErrUnimplementedOpcode}
 	}
 }
+
 func extendedInstructionNotImplemented(op byte) instruction {
 	return func(c *CPU) {
-		log.Panicf("unimplemented extended instruction 0xCB 0x%02X", op)
+		c.PC = c.MMU.pc
+		c.fault = &OpcodeError{PC: c.MMU.pc, Op: op, Extended: true, Err: ErrUnimplementedOpcode}
+	}
+}
+
+func illegalInstruction(op byte) instruction {
+	return func(c *CPU) {
+		c.PC = c.MMU.pc
+		c.hung = true
+		c.MMU.fail(c.PC, &OpcodeError{PC: c.PC, Op: op, Err: ErrIllegalOpcode})
 	}
 }
 
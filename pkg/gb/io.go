@@ -0,0 +1,48 @@
+package gb
+
+// ioUnused holds the bits of each I/O register in 0xFF00-0xFF7F that aren't
+// implemented on the DMG and always read back as 1. Registers that read as
+// 0xFF are either write only or not connected at all.
+var ioUnused = [0x80]byte{
+	// P1 SB SC -- DIV TIMA TMA TAC
+	0xC0, 0x00, 0x7E, 0xFF, 0x00, 0x00, 0x00, 0xF8,
+	// -- ... IF
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xE0,
+	// NR10 NR11 NR12 NR13 NR14 -- NR21 NR22
+	0x80, 0x3F, 0x00, 0xFF, 0xBF, 0xFF, 0x3F, 0x00,
+	// NR23 NR24 NR30 NR31 NR32 NR33 NR34 --
+	0xFF, 0xBF, 0x7F, 0xFF, 0x9F, 0xFF, 0xBF, 0xFF,
+	// NR41 NR42 NR43 NR44 NR50 NR51 NR52 --
+	0xFF, 0x00, 0x00, 0xBF, 0x00, 0x00, 0x70, 0xFF,
+	// --
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	// wave ram
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	// LCDC STAT SCY SCX LY LYC DMA BGP
+	0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	// OBP0 OBP1 WY WX -- ...
+	0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF,
+	// BOOT -- ...
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+}
+
+// ioConnected reports whether there is a register behind an address in
+// 0xFF00-0xFF7F. Reads from the others float to 0xFF and writes are ignored.
+func ioConnected(a uint16) bool {
+	switch {
+	case a == 0xFF03,
+		a >= 0xFF08 && a <= 0xFF0E,
+		a == 0xFF15,
+		a == 0xFF1F,
+		a >= 0xFF27 && a <= 0xFF2F,
+		a >= 0xFF4C && a <= 0xFF7F && a != 0xFF50:
+		return false
+	}
+	return true
+}
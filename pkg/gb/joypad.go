@@ -0,0 +1,43 @@
+package gb
+
+// Buttons is a set of pressed joypad buttons. The low nibble holds the
+// direction keys and the high nibble the action buttons, each in the bit
+// order they are read back from JOYP.
+type Buttons byte
+
+const (
+	ButtonRight Buttons = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+// SetButtons sets which buttons are currently held. Newly pressed buttons
+// request the joypad interrupt.
+func (m *MMU) SetButtons(b Buttons) {
+	if b&^m.buttons != 0 {
+		m.IF |= BitJoypad
+	}
+	m.buttons = b
+}
+
+func (m *MMU) Buttons() Buttons {
+	return m.buttons
+}
+
+// readJoypad returns JOYP. Bits 4 and 5 select the direction keys and action
+// buttons when cleared, and pressed buttons in a selected group read as 0.
+func (m *MMU) readJoypad() byte {
+	keys := byte(0x0F)
+	if m.joyp&0x10 == 0 {
+		keys &^= byte(m.buttons) & 0x0F
+	}
+	if m.joyp&0x20 == 0 {
+		keys &^= byte(m.buttons) >> 4
+	}
+	return 0xC0 | m.joyp | keys
+}
@@ -0,0 +1,28 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoypad(t *testing.T) {
+	mmu := NewMMU(nil, nil, nil, nil)
+
+	mmu.WriteByte(0xFF00, 0x30)
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF00), "nothing selected")
+
+	mmu.SetButtons(ButtonRight | ButtonStart)
+	require.Equal(t, BitJoypad, mmu.IF&BitJoypad)
+	require.Equal(t, byte(0xFF), mmu.ReadByte(0xFF00))
+
+	mmu.WriteByte(0xFF00, 0x20)
+	require.Equal(t, byte(0xEE), mmu.ReadByte(0xFF00), "directions")
+
+	mmu.WriteByte(0xFF00, 0x10)
+	require.Equal(t, byte(0xD7), mmu.ReadByte(0xFF00), "actions")
+
+	mmu.IF = 0
+	mmu.SetButtons(ButtonStart)
+	require.Zero(t, mmu.IF, "releasing a button doesn't interrupt")
+}
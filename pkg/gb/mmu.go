@@ -2,6 +2,7 @@ package gb
 
 import (
 	"fmt"
+	"hash/crc32"
 	"log"
 	"os"
 	"strings"
@@ -20,108 +21,279 @@ func (bf ByteFlag) String() string {
 }
 
 type MMU struct {
-	booted bool // $00-$FF point to cartridge after booting
-	boot   []byte
-	rom    []byte
-	wram   []byte
-	hram   []byte
-	IF     ByteFlag
-	IE     ByteFlag
-	SB     byte
-	SC     byte
-	BGP    byte // background and window palette
-	tma    byte // timer modulo
-	gpu    Module
-	apu    Module
-	joyp   byte
+	booted  bool // $00-$FF point to cartridge after booting
+	boot    []byte
+	rom     []byte
+	romSum  uint32 // CRC-32 of the cartridge as loaded, identifies it in save states
+	bank    int    // rom bank mapped at 0x4000-0x7FFF
+	banking bool   // writes to 0x2000-0x3FFF select the bank, see WithROMBanking
+	wram    []byte
+	hram    []byte
+	IF      ByteFlag
+	IE      ByteFlag
+	SB      byte
+	SC      byte
+	BGP     byte // background and window palette
+	tma     byte // timer modulo
+	gpu     Module
+	apu     Module
+	joyp    byte
+
+	buttons Buttons // held joypad buttons
+
+	link        Link
+	serialTimer int // cycles until an internally clocked transfer completes, 0 when idle
+
+	// Game Boy Color mode, selected by the cartridge header
+	cgb         bool
+	svbk        byte // WRAM bank at 0xD000-0xDFFF
+	key1        byte // bit 0 prepares a speed switch for the next STOP
+	doubleSpeed bool
+	hdma        hdma
+	stall       int // cycles the CPU is stalled by DMA
+
+	policy UnmappedPolicy
+	fault  error           // first access error of the current instruction
+	warned map[uint16]bool // unmapped addresses that were already logged
+
+	pc          uint16 // address of the instruction being executed
+	watchpoints []Watchpoint
+	watchID     int
+	watchHits   []WatchHit
 }
 
 func NewMMU(bootRom, cartRom []uint8, gpu, apu Module) *MMU {
-	return &MMU{
-		boot: bootRom,
-		rom:  cartRom,
-		wram: make([]byte, 8*1024),
-		hram: make([]byte, 256),
-		IF:   0,
-		gpu:  gpu,
-		apu:  apu,
+	m := &MMU{
+		boot:   bootRom,
+		rom:    cartRom,
+		romSum: crc32.ChecksumIEEE(cartRom),
+		bank:   1,
+		wram:   make([]byte, 8*1024),
+		hram:   make([]byte, 256),
+		IF:     0,
+		gpu:    gpu,
+		apu:    apu,
+		cgb:    IsCGB(cartRom),
+
+		warned: make(map[uint16]bool),
+	}
+	if m.cgb {
+		// 8 banks of 4 KiB
+		m.wram = make([]byte, 32*1024)
 	}
+	for _, mod := range []Module{gpu, apu} {
+		if c, ok := mod.(cgbModule); ok {
+			c.SetCGB(m.cgb)
+		}
+	}
+	if h, ok := gpu.(hblankModule); ok && m.cgb {
+		h.OnHBlank(m.hdmaHBlank)
+	}
+	return m
 }
 
 func ReadRom(path string) ([]byte, error) {
 	return os.ReadFile(path)
 }
 
+// WithROMBanking lets writes to 0x2000-0x3FFF select the ROM bank mapped at
+// 0x4000-0x7FFF, the way MBC1 and MBC5 carts do. Cartridge mappers aren't
+// emulated otherwise, this is enough for GBS rips whose data lies beyond
+// 32 KiB.
+func WithROMBanking() Option {
+	return func(c *CPU) {
+		c.MMU.banking = true
+	}
+}
+
 func (m *MMU) ReadByte(a uint16) byte {
+	b := m.load(a)
+	if len(m.watchpoints) > 0 {
+		m.checkWatchpoints(a, false, b, b)
+	}
+	return b
+}
+
+// Peek reads a byte without triggering watchpoints, e.g. for debuggers and
+// tracing that shouldn't be mistaken for accesses by the running program.
+// Unmapped addresses read as 0xFF.
+func (m *MMU) Peek(a uint16) byte {
+	b, err := m.read(a)
+	if err != nil {
+		return 0xFF
+	}
+	return b
+}
+
+// Poke is the write side of Peek, for debuggers changing memory. It doesn't
+// trigger watchpoints or the unmapped policy, and a write to ROM patches the
+// mapped byte instead of selecting a bank.
+func (m *MMU) Poke(a uint16, n byte) error {
+	if a < 0x8000 {
+		i := int(a)
+		if a >= 0x4000 {
+			i += (m.bank - 1) * 0x4000
+		}
+		if i >= len(m.rom) {
+			return ErrUnmapped
+		}
+		m.rom[i] = n
+		return nil
+	}
+	return m.write(a, n)
+}
+
+// load reads a byte on behalf of the running program, applying the unmapped
+// policy
+func (m *MMU) load(a uint16) byte {
+	b, err := m.read(a)
+	if err != nil {
+		m.unmapped(&AccessError{Addr: a, PC: m.pc, Err: err})
+		return 0xFF
+	}
+	return b
+}
+
+// unmapped handles an access error according to the policy
+func (m *MMU) unmapped(err *AccessError) {
+	m.fail(err.Addr, err)
+}
+
+// fail handles a fault according to the unmapped policy, it either fails
+// the instruction or is logged the first time it happens at addr
+func (m *MMU) fail(addr uint16, err error) {
+	if m.policy == UnmappedStop {
+		if m.fault == nil {
+			m.fault = err
+		}
+		return
+	}
+	if !m.warned[addr] {
+		m.warned[addr] = true
+		log.Printf("warning: %v", err)
+	}
+}
+
+// takeFault returns and clears the access error recorded since the last call
+func (m *MMU) takeFault() error {
+	err := m.fault
+	m.fault = nil
+	return err
+}
+
+func (m *MMU) read(a uint16) (byte, error) {
+	if a >= 0xFF00 && a < 0xFF80 {
+		// write only and unconnected registers read as 0xFF, and unused
+		// bits of the others as 1
+		mask := m.ioMask(a)
+		if mask == 0xFF {
+			return 0xFF, nil
+		}
+		b, err := m.readMapped(a)
+		return b | mask, err
+	}
+	return m.readMapped(a)
+}
+
+func (m *MMU) readMapped(a uint16) (byte, error) {
 	switch {
 	case a >= 0x0000 && a < 0x8000:
 		if a <= 0xFF && !m.booted {
-			return m.boot[a]
+			return m.boot[a], nil
+		}
+		i := int(a)
+		if a >= 0x4000 {
+			i += (m.bank - 1) * 0x4000
+		}
+		if i >= len(m.rom) {
+			return 0, ErrUnmapped
 		}
-		return m.rom[a]
+		return m.rom[i], nil
 	case a >= 0x8000 && a < 0xA000:
-		m.gpu.ReadByte(a)
+		return m.gpu.ReadByte(a)
 	case a >= 0xC000 && a < 0xE000:
 		// working ram
-		return m.wram[a-0xC000]
+		return m.wram[m.wramIndex(a)], nil
 	case a >= 0xE000 && a < 0xFE00:
 		// echo ram
-		return m.wram[a-0xE000]
+		return m.wram[m.wramIndex(a-0x2000)], nil
 	case a >= 0xFE00 && a <= 0xFE9F:
 		return m.gpu.ReadByte(a)
 	case a >= 0xFEA0 && a <= 0xFEFF:
-		return 0x00
+		return 0x00, nil
 	case a == 0xFF00:
-		if m.joyp&0x10 == 0x10 {
-			// todo: read direction buttons
-			btns := 0x0
-			return m.joyp&0xF0 | byte(btns)
-		} else if m.joyp&0x20 == 0x20 {
-			// todo: read action buttons
-			btns := 0x0
-			return m.joyp&0xF0 | byte(btns)
-		}
-		return m.joyp & 0xF0
+		return m.readJoypad(), nil
 	case a == 0xFF01:
 		// SB - serial transfer data
-		return m.SB
+		return m.SB, nil
 	case a == 0xFF02:
 		// SC - serial transfer control
-		return m.SC
+		return m.SC, nil
 	case a == 0xFF06:
-		return m.tma
+		return m.tma, nil
 	case a == 0xFF0F:
 		// IF Interrupt flag
-		return byte(m.IF)
-	case a >= 0xFF10 && a <= 0xFF26:
+		return byte(m.IF), nil
+	case a >= 0xFF10 && a <= 0xFF26, a >= 0xFF30 && a <= 0xFF3F:
+		// sound registers and wave RAM
 		return m.apu.ReadByte(a)
-	case a >= 0xFF40 && a <= 0xFF4B:
+	case a >= 0xFF40 && a <= 0xFF4B, a == 0xFF4F, a >= 0xFF68 && a <= 0xFF6B:
 		return m.gpu.ReadByte(a)
+	case a == 0xFF4D:
+		// KEY1 - speed switch
+		b := m.key1 & 1
+		if m.doubleSpeed {
+			b |= 0x80
+		}
+		return b, nil
+	case a == 0xFF70:
+		// SVBK - WRAM bank
+		return m.svbk, nil
+	case a == 0xFF55:
+		return m.readHDMA5(), nil
 	case a >= 0xFF80 && a < 0xFFFF:
-		return m.hram[a-0xFF80]
+		return m.hram[a-0xFF80], nil
 	case a == 0xFFFF:
 		// IE Interrupt enable
-		return byte(m.IE)
-	default:
-		log.Panicf("unimplemented mmu address: 0x%04X", a)
+		return byte(m.IE), nil
 	}
-	return 0
+	return 0, ErrUnmapped
 }
 
 func (m *MMU) WriteByte(a uint16, n uint8) {
+	if len(m.watchpoints) > 0 && m.watched(a, true) {
+		m.checkWatchpoints(a, true, m.Peek(a), n)
+	}
+	if err := m.write(a, n); err != nil {
+		m.unmapped(&AccessError{Addr: a, Write: true, Value: n, PC: m.pc, Err: err})
+	}
+}
+
+func (m *MMU) write(a uint16, n uint8) error {
+	if a >= 0xFF00 && a < 0xFF80 && !m.ioConnected(a) {
+		// not connected
+		return nil
+	}
+
 	switch {
+	case a >= 0x2000 && a <= 0x3FFF && m.banking && len(m.rom) > 0x8000:
+		// bank 0 selects bank 1
+		m.bank = int(n) % (len(m.rom) / 0x4000)
+		if m.bank == 0 {
+			m.bank = 1
+		}
 	case a >= 0x0000 && a <= 0x3FFF:
 		// this is rom but tetris will try to
 		// write to it, skip this
 		fmt.Printf("warning: skipping over write to rom 0x%04X 0x%02X\n", a, n)
 	case a >= 0x8000 && a < 0xA000:
-		m.gpu.WriteByte(a, n)
-	case a >= 0xC000 && a <= 0xE000:
+		return m.gpu.WriteByte(a, n)
+	case a >= 0xC000 && a < 0xE000:
 		// working ram
-		m.wram[a-0xC000] = n
-	case a >= 0xE000 && a <= 0xFE00:
+		m.wram[m.wramIndex(a)] = n
+	case a >= 0xE000 && a < 0xFE00:
 		// echo of working ram
-		m.wram[a-0xE000] = n
+		m.wram[m.wramIndex(a-0x2000)] = n
 	case a == 0xFF00:
 		m.joyp = n & 0x30
 	case a == 0xFF01:
@@ -129,34 +301,39 @@ func (m *MMU) WriteByte(a uint16, n uint8) {
 		m.SB = n
 	case a == 0xFF02:
 		// SC - serial transfer control
-		// todo: not implemented
-		m.SC = n
+		m.writeSC(n)
 	case a == 0xFF06:
 		m.tma = n
 	case a == 0xFF0F:
 		// IF - Interrupt Flag
 		m.IF = ByteFlag(n)
-	case a >= 0xFF10 && a <= 0xFF26:
-		m.apu.WriteByte(a, n)
-	case a >= 0xFF40 && a <= 0xFF4B:
-		m.gpu.WriteByte(a, n)
+	case a >= 0xFF10 && a <= 0xFF26, a >= 0xFF30 && a <= 0xFF3F:
+		// sound registers and wave RAM
+		return m.apu.WriteByte(a, n)
+	case a >= 0xFF40 && a <= 0xFF4B, a == 0xFF4F, a >= 0xFF68 && a <= 0xFF6B:
+		return m.gpu.WriteByte(a, n)
+	case a == 0xFF4D:
+		m.key1 = n & 1
+	case a == 0xFF70:
+		m.svbk = n & 7
+	case a >= 0xFF51 && a <= 0xFF55:
+		m.writeHDMA(a, n)
 	case a == 0xFF50:
 		m.booted = n != 0
 	case a >= 0xFF80 && a < 0xFFFF:
 		// high ram
 		m.hram[a-0xFF80] = n
 	case a >= 0xFE00 && a <= 0xFE9F:
-		m.gpu.WriteByte(a, n)
+		return m.gpu.WriteByte(a, n)
 	case a >= 0xFEA0 && a <= 0xFEFF:
 		// unusable area
-	case a >= 0xFF4C && a <= 0xFF7F:
-		// unusable area
 	case a == 0xFFFF:
 		// IE - Interrupt Enable
 		m.IE = ByteFlag(n)
 	default:
-		log.Panicf("cannot write to mmu 0x%04X = 0x%02X", a, n)
+		return ErrUnmapped
 	}
+	return nil
 }
 
 const (
@@ -1,8 +1,11 @@
 package gb
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
 	"github.com/stretchr/testify/require"
 )
 
@@ -11,3 +14,93 @@ func TestMMU(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, rom)
 }
+
+func TestWorkRAMRanges(t *testing.T) {
+	g := newTestGameboy(nil, WithUnmappedPolicy(UnmappedStop))
+	mmu := g.CPU().MMU
+
+	mmu.WriteByte(0xDFFF, 0x12)
+	require.Equal(t, byte(0x12), mmu.ReadByte(0xDFFF), "last byte of wram")
+	mmu.WriteByte(0xE000, 0x34)
+	require.Equal(t, byte(0x34), mmu.ReadByte(0xC000), "echo starts at 0xE000")
+	mmu.WriteByte(0xFDFF, 0x56)
+	require.Equal(t, byte(0x56), mmu.ReadByte(0xDDFF), "and ends at 0xFDFF")
+
+	// 0xFE00 is OAM, not the end of echo ram
+	mmu.WriteByte(0xFE00, 0x78)
+	require.Equal(t, byte(0x78), mmu.ReadByte(0xFE00))
+	require.Zero(t, mmu.ReadByte(0xDE00))
+	require.NoError(t, mmu.takeFault())
+}
+
+func TestVRAMReads(t *testing.T) {
+	g := newTestGameboy(nil, WithUnmappedPolicy(UnmappedStop))
+	mmu := g.CPU().MMU
+
+	mmu.WriteByte(0x8000, 0x12)
+	mmu.WriteByte(0x9FFF, 0x34)
+	require.Equal(t, byte(0x12), mmu.ReadByte(0x8000))
+	require.Equal(t, byte(0x34), mmu.ReadByte(0x9FFF))
+	require.NoError(t, mmu.takeFault())
+}
+
+func TestROMBanking(t *testing.T) {
+	// four banks, each filled with its own number
+	rom := make([]byte, 4*0x4000)
+	for i := range rom {
+		rom[i] = byte(i / 0x4000)
+	}
+	mmu := NewGameboy(nil, rom, gpu.New(), apu.New()).CPU().MMU
+	mmu.WriteByte(0x2000, 3)
+	require.Equal(t, byte(1), mmu.ReadByte(0x4000), "banking is off without WithROMBanking")
+
+	mmu = NewGameboy(nil, rom, gpu.New(), apu.New(), WithROMBanking()).CPU().MMU
+	require.Equal(t, byte(0), mmu.ReadByte(0x0000))
+	require.Equal(t, byte(1), mmu.ReadByte(0x4000), "bank 1 is mapped at power on")
+
+	mmu.WriteByte(0x2000, 3)
+	require.Equal(t, byte(3), mmu.ReadByte(0x4000))
+	require.Equal(t, byte(3), mmu.ReadByte(0x7FFF))
+	require.Equal(t, byte(0), mmu.ReadByte(0x3FFF), "bank 0 stays put")
+
+	mmu.WriteByte(0x3FFF, 2)
+	require.Equal(t, byte(2), mmu.ReadByte(0x4000))
+
+	mmu.WriteByte(0x2000, 0)
+	require.Equal(t, byte(1), mmu.ReadByte(0x4000), "bank 0 selects bank 1")
+
+	mmu.WriteByte(0x2000, 6)
+	require.Equal(t, byte(2), mmu.ReadByte(0x4000), "the bank wraps at the rom size")
+
+	// a 32 KiB rom has no banks to switch
+	small := make([]byte, 0x8000)
+	small[0x4000] = 0xAB
+	mmu = NewGameboy(nil, small, gpu.New(), apu.New(), WithROMBanking()).CPU().MMU
+	mmu.WriteByte(0x2000, 2)
+	require.Equal(t, byte(0xAB), mmu.ReadByte(0x4000))
+}
+
+func TestROMBankSaveState(t *testing.T) {
+	rom := make([]byte, 4*0x4000)
+	copy(rom[0x100:], stateTestProgram)
+	for i := 0x4000; i < len(rom); i++ {
+		rom[i] = byte(i / 0x4000)
+	}
+	newCPU := func() *CPU {
+		gpu := gpu.New()
+		mmu := NewMMU(nil, rom, gpu, apu.New())
+		mmu.booted = true
+		cpu := NewCPU(mmu, gpu, false, WithROMBanking())
+		cpu.PC = 0x100
+		return cpu
+	}
+
+	a := newCPU()
+	a.MMU.WriteByte(0x2000, 3)
+	saved := snapshot(t, a)
+
+	b := newCPU()
+	require.Equal(t, byte(1), b.MMU.ReadByte(0x4000))
+	require.NoError(t, b.LoadState(bytes.NewReader(saved)))
+	require.Equal(t, byte(3), b.MMU.ReadByte(0x4000), "the selected bank is restored")
+}
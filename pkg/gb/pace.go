@@ -0,0 +1,72 @@
+package gb
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ClockSpeed is the CPU's clock in T cycles per second
+const ClockSpeed = 4194304
+
+// FrameRate is the LCD's refresh rate, about 59.7275 Hz
+const FrameRate = float64(ClockSpeed) / CyclesPerFrame
+
+// maxLag is how far emulation may fall behind before the pacer gives up on
+// catching up, e.g. after the process was suspended
+const maxLag = 4
+
+// pacer spaces out frames so that emulated time passes at a multiple of real
+// time. It only sleeps at frame boundaries, within a frame the CPU runs as
+// fast as it can.
+type pacer struct {
+	next  time.Time // when the next frame is due
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newPacer() *pacer {
+	return &pacer{now: time.Now, sleep: time.Sleep}
+}
+
+// frame waits until the next frame is due at the given speed. Speed 0 doesn't
+// wait at all.
+func (p *pacer) frame(speed float64) {
+	now := p.now()
+	if speed <= 0 {
+		p.next = now
+		return
+	}
+
+	period := framePeriod(speed)
+	if p.next.IsZero() || now.Sub(p.next) > maxLag*period {
+		p.next = now
+	}
+	p.next = p.next.Add(period)
+	if d := p.next.Sub(now); d > 0 {
+		p.sleep(d)
+	}
+}
+
+// framePeriod is the real time a frame takes at the given speed
+func framePeriod(speed float64) time.Duration {
+	return time.Duration(float64(time.Second) / (FrameRate * speed))
+}
+
+// WithSpeed sets the initial emulation speed, see SetSpeed
+func WithSpeed(speed float64) Option {
+	return func(c *CPU) {
+		c.SetSpeed(speed)
+	}
+}
+
+// SetSpeed sets how fast Run emulates relative to real hardware, e.g. 2 for
+// double speed or 0.25 for slow motion. Speed 0 runs unthrottled. It is safe
+// to call from any goroutine.
+func (c *CPU) SetSpeed(speed float64) {
+	atomic.StoreUint64(&c.speed, math.Float64bits(speed))
+}
+
+func (c *CPU) Speed() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.speed))
+}
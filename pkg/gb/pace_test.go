@@ -0,0 +1,63 @@
+package gb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock only moves when the pacer sleeps or the test advances it
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (f *fakeClock) pacer() *pacer {
+	return &pacer{
+		now: func() time.Time { return f.now },
+		sleep: func(d time.Duration) {
+			f.slept = append(f.slept, d)
+			f.now = f.now.Add(d)
+		},
+	}
+}
+
+func TestPacer(t *testing.T) {
+	period := framePeriod(1)
+	require.InDelta(t, 59.7275, FrameRate, 0.0001)
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	p := clock.pacer()
+
+	p.frame(1)
+	require.Equal(t, []time.Duration{period}, clock.slept)
+
+	// time spent emulating the frame is taken off the sleep
+	clock.slept = nil
+	clock.now = clock.now.Add(period / 4)
+	p.frame(1)
+	require.Equal(t, []time.Duration{period - period/4}, clock.slept)
+
+	clock.slept = nil
+	p.frame(2)
+	p.frame(0.25)
+	require.Equal(t, []time.Duration{framePeriod(2), framePeriod(0.25)}, clock.slept)
+
+	clock.slept = nil
+	p.frame(0)
+	require.Empty(t, clock.slept, "unthrottled")
+
+	// falling far behind resets the schedule instead of racing to catch up
+	clock.now = clock.now.Add(time.Second)
+	p.frame(1)
+	require.Equal(t, []time.Duration{period}, clock.slept)
+}
+
+func TestSpeed(t *testing.T) {
+	cpu := NewCPU(nil, nil, false)
+	require.Equal(t, 1.0, cpu.Speed())
+	cpu.SetSpeed(0.25)
+	require.Equal(t, 0.25, cpu.Speed())
+	require.Equal(t, 4.0, NewCPU(nil, nil, false, WithSpeed(4)).Speed())
+}
@@ -0,0 +1,83 @@
+package gb
+
+// serialPeriod is the number of cycles a transfer takes with the internal
+// 8192 Hz clock, one bit every 512 cycles
+const serialPeriod = 8 * 512
+
+// serialPeriodFast is a transfer with the 262144 Hz clock a CGB selects with
+// bit 1 of SC, one bit every 16 cycles
+const serialPeriodFast = 8 * 16
+
+// Link is the other end of the link cable, another Game Boy or a device
+// such as the printer. Its methods are called on the emulation goroutine.
+type Link interface {
+	// Transfer is called when this side, as the clock master, has shifted
+	// b out. It returns the byte the other side shifted in, 0xFF when
+	// nothing answers. It may block while waiting for the other side.
+	Transfer(b byte) byte
+
+	// Poll returns a byte the other side shifted out while clocking this
+	// side, if there is one. Each polled byte must be answered with Reply.
+	Poll() (b byte, ok bool)
+	Reply(b byte)
+}
+
+// WithLink connects the serial port to a link cable
+func WithLink(l Link) Option {
+	return func(c *CPU) {
+		c.MMU.link = l
+	}
+}
+
+// writeSC starts a transfer when bit 7 is set with the internal clock
+// selected in bit 0, in CGB mode bit 1 selects the fast clock. With the
+// external clock the transfer waits for the other side to clock it.
+func (m *MMU) writeSC(n byte) {
+	m.SC = n
+	if n&0x81 == 0x81 {
+		m.serialTimer = serialPeriod
+		if m.cgb && n&0x02 != 0 {
+			m.serialTimer = serialPeriodFast
+		}
+	} else {
+		m.serialTimer = 0
+	}
+}
+
+// stepSerial finishes internally clocked transfers and answers transfers
+// clocked by the other side
+func (m *MMU) stepSerial(cycles int) {
+	if m.serialTimer > 0 {
+		m.serialTimer -= cycles
+		if m.serialTimer <= 0 {
+			m.serialTimer = 0
+			in := byte(0xFF)
+			if m.link != nil {
+				in = m.link.Transfer(m.SB)
+			}
+			m.finishTransfer(in)
+		}
+	}
+
+	if m.link == nil {
+		return
+	}
+	if b, ok := m.link.Poll(); ok {
+		if m.SC&0x81 != 0x80 {
+			// no transfer waiting on the external clock, the line floats
+			m.link.Reply(0xFF)
+			return
+		}
+		m.link.Reply(m.SB)
+		m.finishTransfer(b)
+	}
+}
+
+// finishTransfer latches the received byte and requests the serial interrupt.
+// Like the other interrupt sources it only sets the flag in IF, the CPU
+// doesn't dispatch interrupts.
+func (m *MMU) finishTransfer(in byte) {
+	m.SB = in
+	m.SC &^= 0x80
+	m.IF |= BitSerial
+}
@@ -0,0 +1,141 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLink answers transfers with a fixed byte and can clock this side
+type fakeLink struct {
+	answer  byte
+	sent    []byte
+	pending []byte
+	replies []byte
+}
+
+func (l *fakeLink) Transfer(b byte) byte {
+	l.sent = append(l.sent, b)
+	return l.answer
+}
+
+func (l *fakeLink) Poll() (byte, bool) {
+	if len(l.pending) == 0 {
+		return 0, false
+	}
+	b := l.pending[0]
+	l.pending = l.pending[1:]
+	return b, true
+}
+
+func (l *fakeLink) Reply(b byte) {
+	l.replies = append(l.replies, b)
+}
+
+// serialProgram loads SB and starts a transfer with the given SC value
+func serialProgram(sb, sc byte) []byte {
+	return []byte{
+		0x3E, sb, 0xE0, 0x01, // LD A, sb; LDH (SB), A
+		0x3E, sc, 0xE0, 0x02, // LD A, sc; LDH (SC), A
+		0x18, 0xFE, // JR -2
+	}
+}
+
+func TestSerialInternalClock(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		link Link
+		want byte
+	}{
+		{"unplugged", nil, 0xFF},
+		{"linked", &fakeLink{answer: 0x5A}, 0x5A},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []Option
+			if tc.link != nil {
+				opts = append(opts, WithLink(tc.link))
+			}
+			g := newTestGameboy(serialProgram(0x42, 0x81), opts...)
+			cpu := g.CPU()
+			for i := 0; i < 4; i++ {
+				require.NoError(t, g.Step())
+			}
+			start := cpu.T
+
+			for cpu.MMU.SC&0x80 != 0 {
+				require.Zero(t, cpu.MMU.IF&BitSerial, "interrupt before the transfer finished")
+				require.NoError(t, g.Step())
+			}
+			require.InDelta(t, serialPeriod, cpu.T-start, 12)
+			require.Equal(t, tc.want, cpu.MMU.SB)
+			require.NotZero(t, cpu.MMU.IF&BitSerial)
+			if l, ok := tc.link.(*fakeLink); ok {
+				require.Equal(t, []byte{0x42}, l.sent)
+			}
+		})
+	}
+}
+
+func TestSerialFastClock(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cgb    bool
+		period int
+	}{
+		{"dmg", false, serialPeriod},
+		{"cgb", true, serialPeriodFast},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestGameboy(serialProgram(0x42, 0x83))
+			if tc.cgb {
+				g = newTestCGB(serialProgram(0x42, 0x83))
+			}
+			cpu := g.CPU()
+			for i := 0; i < 4; i++ {
+				require.NoError(t, g.Step())
+			}
+			start := cpu.T
+			for cpu.MMU.SC&0x80 != 0 {
+				require.NoError(t, g.Step())
+			}
+			require.InDelta(t, tc.period, cpu.T-start, 12)
+			require.Equal(t, byte(0x7F), cpu.MMU.ReadByte(0xFF02))
+		})
+	}
+
+	// bit 1 only reads back in CGB mode
+	g := newTestGameboy(nil)
+	g.CPU().MMU.WriteByte(0xFF02, 0x00)
+	require.Equal(t, byte(0x7E), g.CPU().MMU.ReadByte(0xFF02))
+	g = newTestCGB(nil)
+	g.CPU().MMU.WriteByte(0xFF02, 0x00)
+	require.Equal(t, byte(0x7C), g.CPU().MMU.ReadByte(0xFF02))
+}
+
+func TestSerialExternalClock(t *testing.T) {
+	l := &fakeLink{pending: []byte{0x11}}
+	g := newTestGameboy(serialProgram(0x99, 0x00), WithLink(l))
+
+	// not waiting on the external clock yet, the line floats
+	require.NoError(t, g.Step())
+	require.Equal(t, []byte{0xFF}, l.replies)
+
+	g = newTestGameboy(serialProgram(0x99, 0x80), WithLink(l))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, g.Step())
+	}
+	mmu := g.CPU().MMU
+	require.Equal(t, byte(0x80), mmu.SC&0x80)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, g.Step())
+	}
+	require.Equal(t, byte(0x80), mmu.SC&0x80, "waits for the other side")
+
+	l.pending = []byte{0x22}
+	require.NoError(t, g.Step())
+	require.Equal(t, []byte{0xFF, 0x99}, l.replies)
+	require.Equal(t, byte(0x22), mmu.SB)
+	require.Zero(t, mmu.SC&0x80)
+	require.NotZero(t, mmu.IF&BitSerial)
+	require.Empty(t, l.sent)
+}
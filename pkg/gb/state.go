@@ -0,0 +1,269 @@
+package gb
+
+import (
+	"encoding"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StateVersion is bumped whenever the save state layout changes so that old
+// states are rejected instead of silently restoring garbage
+const StateVersion = 12
+
+var stateMagic = [4]byte{'G', 'B', 'C', 'S'}
+
+var ErrStateVersion = errors.New("unsupported save state version")
+
+var ErrStateROM = errors.New("save state is from another cartridge")
+
+type cpuState struct {
+	R        []byte
+	SP       uint16
+	PC       uint16
+	M        int
+	T        int
+	IME      bool
+	ShouldDI bool
+	ShouldEI bool
+	Hung     bool
+	Stopped  bool
+}
+
+// mmuState has no timer or cartridge RAM fields because the MMU implements
+// neither, the only timer register is TMA and the cartridge is plain ROM with
+// a bank register
+type mmuState struct {
+	Booted  bool
+	Bank    int
+	WRAM    []byte
+	HRAM    []byte
+	IF      byte
+	IE      byte
+	SB      byte
+	SC      byte
+	Serial  int
+	BGP     byte
+	TMA     byte
+	JOYP    byte
+	Buttons byte
+
+	SVBK        byte
+	KEY1        byte
+	DoubleSpeed bool
+	HDMASrc     uint16
+	HDMADst     uint16
+	HDMABlocks  int
+	HDMAHBlank  bool
+	Stall       int
+}
+
+type machineState struct {
+	Version int
+	ROM     uint32 // CRC-32 of the cartridge
+	CPU     cpuState
+	MMU     mmuState
+	GPU     []byte
+	APU     []byte
+}
+
+// SaveState writes a snapshot of the whole machine to w
+func (c *CPU) SaveState(w io.Writer) error {
+	s := machineState{
+		Version: StateVersion,
+		ROM:     c.MMU.romSum,
+		CPU: cpuState{
+			R:        c.R,
+			SP:       c.SP,
+			PC:       c.PC,
+			M:        c.M,
+			T:        c.T,
+			IME:      c.IME,
+			ShouldDI: c.shouldDI,
+			ShouldEI: c.shouldEI,
+			Hung:     c.hung,
+			Stopped:  c.stopped,
+		},
+		MMU: mmuState{
+			Booted:  c.MMU.booted,
+			Bank:    c.MMU.bank,
+			WRAM:    c.MMU.wram,
+			HRAM:    c.MMU.hram,
+			IF:      byte(c.MMU.IF),
+			IE:      byte(c.MMU.IE),
+			SB:      c.MMU.SB,
+			SC:      c.MMU.SC,
+			Serial:  c.MMU.serialTimer,
+			BGP:     c.MMU.BGP,
+			TMA:     c.MMU.tma,
+			JOYP:    c.MMU.joyp,
+			Buttons: byte(c.MMU.buttons),
+
+			SVBK:        c.MMU.svbk,
+			KEY1:        c.MMU.key1,
+			DoubleSpeed: c.MMU.doubleSpeed,
+			HDMASrc:     c.MMU.hdma.src,
+			HDMADst:     c.MMU.hdma.dst,
+			HDMABlocks:  c.MMU.hdma.blocks,
+			HDMAHBlank:  c.MMU.hdma.hblank,
+			Stall:       c.MMU.stall,
+		},
+	}
+
+	var err error
+	if s.GPU, err = marshalModule("gpu", c.MMU.gpu); err != nil {
+		return err
+	}
+	if s.APU, err = marshalModule("apu", c.MMU.apu); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(stateMagic[:]); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(s)
+}
+
+// LoadState restores a snapshot written by SaveState. Snapshots that can't be
+// decoded, come from another version or were taken with another cartridge
+// are rejected before anything is restored.
+func (c *CPU) LoadState(r io.Reader) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return fmt.Errorf("read save state: %w", err)
+	}
+	if magic != stateMagic {
+		return errors.New("not a save state")
+	}
+
+	var s machineState
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return fmt.Errorf("decode save state: %w", err)
+	}
+	if s.Version != StateVersion {
+		return fmt.Errorf("%w %d, expected %d", ErrStateVersion, s.Version, StateVersion)
+	}
+	if s.ROM != c.MMU.romSum {
+		return ErrStateROM
+	}
+	if len(s.CPU.R) != len(c.R) || len(s.MMU.WRAM) != len(c.MMU.wram) || len(s.MMU.HRAM) != len(c.MMU.hram) {
+		return errors.New("save state memory sizes don't match this machine")
+	}
+
+	if err := c.restoreModules(s); err != nil {
+		return err
+	}
+
+	copy(c.R, s.CPU.R)
+	c.SP = s.CPU.SP
+	c.PC = s.CPU.PC
+	c.M = s.CPU.M
+	c.T = s.CPU.T
+	c.IME = s.CPU.IME
+	c.shouldDI = s.CPU.ShouldDI
+	c.shouldEI = s.CPU.ShouldEI
+	c.hung = s.CPU.Hung
+	c.stopped = s.CPU.Stopped
+
+	m := c.MMU
+	m.booted = s.MMU.Booted
+	m.bank = s.MMU.Bank
+	copy(m.wram, s.MMU.WRAM)
+	copy(m.hram, s.MMU.HRAM)
+	m.IF = ByteFlag(s.MMU.IF)
+	m.IE = ByteFlag(s.MMU.IE)
+	m.SB = s.MMU.SB
+	m.SC = s.MMU.SC
+	m.serialTimer = s.MMU.Serial
+	m.BGP = s.MMU.BGP
+	m.tma = s.MMU.TMA
+	m.joyp = s.MMU.JOYP
+	m.buttons = Buttons(s.MMU.Buttons)
+	m.svbk = s.MMU.SVBK
+	m.key1 = s.MMU.KEY1
+	m.doubleSpeed = s.MMU.DoubleSpeed
+	m.hdma = hdma{src: s.MMU.HDMASrc, dst: s.MMU.HDMADst, blocks: s.MMU.HDMABlocks, hblank: s.MMU.HDMAHBlank}
+	m.stall = s.MMU.Stall
+	return nil
+}
+
+// SaveStateFile writes a snapshot to path
+func (c *CPU) SaveStateFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadStateFile restores a snapshot from path
+func (c *CPU) LoadStateFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.LoadState(f)
+}
+
+// restoreModules restores the GPU and APU. They are restored one after the
+// other, so if one fails the ones already restored are rolled back and the
+// machine is left as it was.
+func (c *CPU) restoreModules(s machineState) error {
+	mods := []struct {
+		name string
+		m    Module
+		data []byte
+	}{
+		{"gpu", c.MMU.gpu, s.GPU},
+		{"apu", c.MMU.apu, s.APU},
+	}
+
+	undo := make([][]byte, len(mods))
+	for i, mod := range mods {
+		var err error
+		if undo[i], err = marshalModule(mod.name, mod.m); err != nil {
+			return err
+		}
+	}
+	for i, mod := range mods {
+		if err := unmarshalModule(mod.name, mod.m, mod.data); err != nil {
+			for j := i; j >= 0; j-- {
+				unmarshalModule(mods[j].name, mods[j].m, undo[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalModule(name string, m Module) ([]byte, error) {
+	if m == nil {
+		return nil, nil
+	}
+	bm, ok := m.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't support save states", name)
+	}
+	return bm.MarshalBinary()
+}
+
+func unmarshalModule(name string, m Module, data []byte) error {
+	if m == nil {
+		return nil
+	}
+	bu, ok := m.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%s doesn't support save states", name)
+	}
+	if err := bu.UnmarshalBinary(data); err != nil {
+		return fmt.Errorf("restore %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package gb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+// stateTestProgram keeps touching registers, the stack, wram and io
+var stateTestProgram = []byte{
+	0x31, 0xFE, 0xFF, // 0100 LD SP, $FFFE
+	0x21, 0x00, 0xC0, // 0103 LD HL, $C000
+	0x04,       // 0106 INC B
+	0x78,       // 0107 LD A, B
+	0x22,       // 0108 LD (HL+), A
+	0x87,       // 0109 ADD A, A
+	0xC5,       // 010A PUSH BC
+	0xC1,       // 010B POP BC
+	0xE0, 0x42, // 010C LDH ($42), A
+	0x18, 0xF6, // 010E JR $0106
+}
+
+func newStateTestCPU() *CPU {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], stateTestProgram)
+
+	gpu := gpu.New()
+	mmu := NewMMU(nil, rom, gpu, apu.New())
+	mmu.booted = true
+	cpu := NewCPU(mmu, gpu, false)
+	cpu.PC = 0x100
+	return cpu
+}
+
+func snapshot(t *testing.T, c *CPU) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, c.SaveState(&buf))
+	return buf.Bytes()
+}
+
+func TestSaveState(t *testing.T) {
+	a := newStateTestCPU()
+	for i := 0; i < 300; i++ {
+		a.Update()
+	}
+	saved := snapshot(t, a)
+
+	for i := 0; i < 500; i++ {
+		a.Update()
+	}
+
+	b := newStateTestCPU()
+	require.NoError(t, b.LoadState(bytes.NewReader(saved)))
+	require.Equal(t, saved, snapshot(t, b), "loading and saving again round trips")
+	for i := 0; i < 500; i++ {
+		b.Update()
+	}
+
+	require.Equal(t, snapshot(t, a), snapshot(t, b))
+	require.Equal(t, a.PC, b.PC)
+	require.Equal(t, a.R, b.R)
+	require.Equal(t, a.MMU.wram, b.MMU.wram)
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	c := newStateTestCPU()
+
+	require.Error(t, c.LoadState(bytes.NewReader([]byte("nope"))))
+	require.Error(t, c.LoadState(bytes.NewReader(nil)))
+
+	var buf bytes.Buffer
+	buf.Write(stateMagic[:])
+	require.NoError(t, gob.NewEncoder(&buf).Encode(machineState{Version: StateVersion + 1}))
+	err := c.LoadState(&buf)
+	require.True(t, errors.Is(err, ErrStateVersion), err)
+	require.EqualValues(t, 0x100, c.PC, "a rejected state doesn't modify the machine")
+}
+
+func TestLoadStateFromAnotherROM(t *testing.T) {
+	saved := snapshot(t, newStateTestCPU())
+
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], stateTestProgram)
+	rom[0x134] = 'X' // another title
+	gpu := gpu.New()
+	c := NewCPU(NewMMU(nil, rom, gpu, apu.New()), gpu, false)
+	c.PC = 0x150
+
+	err := c.LoadState(bytes.NewReader(saved))
+	require.True(t, errors.Is(err, ErrStateROM), err)
+	require.EqualValues(t, 0x150, c.PC, "a rejected state doesn't modify the machine")
+}
+
+func TestLoadStateIsAllOrNothing(t *testing.T) {
+	c := newStateTestCPU()
+	for i := 0; i < 300; i++ {
+		c.Update()
+	}
+
+	// a state whose apu can't be restored after the gpu was
+	var s machineState
+	saved := snapshot(t, c)
+	require.NoError(t, gob.NewDecoder(bytes.NewReader(saved[len(stateMagic):])).Decode(&s))
+	s.APU = []byte("garbage")
+	var buf bytes.Buffer
+	buf.Write(stateMagic[:])
+	require.NoError(t, gob.NewEncoder(&buf).Encode(s))
+
+	c.MMU.WriteByte(0xFF43, 0x55)
+	before := snapshot(t, c)
+	require.Error(t, c.LoadState(&buf))
+	require.Equal(t, before, snapshot(t, c))
+	require.Equal(t, byte(0x55), c.MMU.ReadByte(0xFF43))
+}
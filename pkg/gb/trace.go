@@ -0,0 +1,28 @@
+package gb
+
+import "fmt"
+
+// writeTrace logs the registers and the next four bytes at PC before an
+// instruction executes. The line format matches gameboy-doctor so a trace can
+// be diffed against known good logs to find the first diverging instruction.
+//
+// A:00 F:11 B:22 C:33 D:44 E:55 H:66 L:77 SP:8888 PC:9999 PCMEM:AA,BB,CC,DD
+func (c *CPU) writeTrace() {
+	if c.trace == nil {
+		return
+	}
+
+	fmt.Fprintf(c.trace,
+		"A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+		c.R[A], c.R[F], c.R[B], c.R[C], c.R[D], c.R[E], c.R[H], c.R[L], c.SP, c.PC,
+		c.MMU.Peek(c.PC), c.MMU.Peek(c.PC+1), c.MMU.Peek(c.PC+2), c.MMU.Peek(c.PC+3),
+	)
+}
+
+// flushTrace flushes buffered trace output, e.g. a bufio.Writer, so the lines
+// leading up to a crash aren't lost
+func (c *CPU) flushTrace() {
+	if f, ok := c.trace.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+}
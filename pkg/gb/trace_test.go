@@ -0,0 +1,39 @@
+package gb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0x3E, 0x12, // LD A, d8
+		0x06, 0x34, // LD B, d8
+		0x00, // NOP
+	})
+
+	var out bytes.Buffer
+	gpu := gpu.New()
+	mmu := NewMMU(nil, rom, gpu, apu.New())
+	mmu.booted = true
+	cpu := NewCPU(mmu, gpu, false, WithTrace(&out))
+	cpu.PC = 0x100
+	cpu.SP = 0xFFFE
+
+	for i := 0; i < 3; i++ {
+		cpu.Update()
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, []string{
+		"A:00 F:00 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0100 PCMEM:3E,12,06,34",
+		"A:12 F:00 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0102 PCMEM:06,34,00,00",
+		"A:12 F:00 B:34 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0104 PCMEM:00,00,00,00",
+	}, lines)
+}
@@ -0,0 +1,121 @@
+package gb
+
+import "fmt"
+
+// Watchpoint observes data accesses to an inclusive range of addresses
+type Watchpoint struct {
+	ID    int // assigned by AddWatchpoint
+	Start uint16
+	End   uint16
+	Read  bool
+	Write bool
+
+	// Match restricts the watchpoint to accesses that read or write Value
+	Match bool
+	Value byte
+}
+
+func (w Watchpoint) String() string {
+	var kind string
+	switch {
+	case w.Read && w.Write:
+		kind = "rw"
+	case w.Read:
+		kind = "r"
+	case w.Write:
+		kind = "w"
+	}
+
+	s := fmt.Sprintf("0x%04X", w.Start)
+	if w.End != w.Start {
+		s += fmt.Sprintf("-0x%04X", w.End)
+	}
+	s += " " + kind
+	if w.Match {
+		s += fmt.Sprintf(" =0x%02X", w.Value)
+	}
+	return s
+}
+
+func (w Watchpoint) covers(a uint16) bool {
+	return a >= w.Start && a <= w.End
+}
+
+// WatchHit records an access that triggered a watchpoint
+type WatchHit struct {
+	ID    int
+	Addr  uint16
+	PC    uint16 // instruction that made the access
+	Write bool
+	Old   byte // value before the access
+	New   byte // value after the access, the same as Old for reads
+}
+
+func (h WatchHit) String() string {
+	if h.Write {
+		return fmt.Sprintf("watchpoint %d: write 0x%04X 0x%02X -> 0x%02X at PC 0x%04X", h.ID, h.Addr, h.Old, h.New, h.PC)
+	}
+	return fmt.Sprintf("watchpoint %d: read 0x%04X = 0x%02X at PC 0x%04X", h.ID, h.Addr, h.Old, h.PC)
+}
+
+// AddWatchpoint starts watching memory and returns an id for removing it
+func (m *MMU) AddWatchpoint(w Watchpoint) int {
+	if w.End < w.Start {
+		w.End = w.Start
+	}
+	m.watchID++
+	w.ID = m.watchID
+	m.watchpoints = append(m.watchpoints, w)
+	return w.ID
+}
+
+func (m *MMU) RemoveWatchpoint(id int) bool {
+	for i, w := range m.watchpoints {
+		if w.ID == id {
+			m.watchpoints = append(m.watchpoints[:i], m.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Watchpoints returns the active watchpoints in the order they were added
+func (m *MMU) Watchpoints() []Watchpoint {
+	return append([]Watchpoint(nil), m.watchpoints...)
+}
+
+// WatchHits returns and clears the accesses that triggered watchpoints since
+// the last call
+func (m *MMU) WatchHits() []WatchHit {
+	hits := m.watchHits
+	m.watchHits = nil
+	return hits
+}
+
+func (m *MMU) watched(a uint16, write bool) bool {
+	for _, w := range m.watchpoints {
+		if w.covers(a) && (write && w.Write || !write && w.Read) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MMU) checkWatchpoints(a uint16, write bool, old, new byte) {
+	for _, w := range m.watchpoints {
+		if !w.covers(a) || write && !w.Write || !write && !w.Read {
+			continue
+		}
+		if w.Match && w.Value != new {
+			continue
+		}
+		m.watchHits = append(m.watchHits, WatchHit{
+			ID:    w.ID,
+			Addr:  a,
+			PC:    m.pc,
+			Write: write,
+			Old:   old,
+			New:   new,
+		})
+	}
+}
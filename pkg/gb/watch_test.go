@@ -0,0 +1,60 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchpoints(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0x3E, 0x05, // 0100 LD A, $05
+		0xEA, 0x00, 0xC0, // 0102 LD ($C000), A
+		0x21, 0x00, 0xC0, // 0105 LD HL, $C000
+		0x56,       // 0108 LD D, (HL)
+		0x3E, 0x07, // 0109 LD A, $07
+		0x77, // 010B LD (HL), A
+	})
+
+	gpu := gpu.New()
+	mmu := NewMMU(nil, rom, gpu, apu.New())
+	mmu.booted = true
+	cpu := NewCPU(mmu, gpu, false)
+	cpu.PC = 0x100
+
+	write := mmu.AddWatchpoint(Watchpoint{Start: 0xC000, Write: true})
+	read := mmu.AddWatchpoint(Watchpoint{Start: 0xBFF0, End: 0xC0FF, Read: true})
+	match := mmu.AddWatchpoint(Watchpoint{Start: 0xC000, Write: true, Match: true, Value: 0x07})
+	mmu.AddWatchpoint(Watchpoint{Start: 0x0100, End: 0x01FF, Read: true, Write: true})
+
+	cpu.Update()
+	require.Empty(t, mmu.WatchHits(), "instruction fetches aren't data accesses")
+
+	cpu.Update()
+	require.Equal(t, []WatchHit{
+		{ID: write, Addr: 0xC000, PC: 0x0102, Write: true, Old: 0x00, New: 0x05},
+	}, mmu.WatchHits())
+	require.Empty(t, mmu.WatchHits(), "hits are cleared once read")
+
+	cpu.Update()
+	cpu.Update()
+	require.Equal(t, []WatchHit{
+		{ID: read, Addr: 0xC000, PC: 0x0108, Old: 0x05, New: 0x05},
+	}, mmu.WatchHits())
+
+	cpu.Update()
+	cpu.Update()
+	require.Equal(t, []WatchHit{
+		{ID: write, Addr: 0xC000, PC: 0x010B, Write: true, Old: 0x05, New: 0x07},
+		{ID: match, Addr: 0xC000, PC: 0x010B, Write: true, Old: 0x05, New: 0x07},
+	}, mmu.WatchHits())
+
+	require.True(t, mmu.RemoveWatchpoint(write))
+	require.False(t, mmu.RemoveWatchpoint(write))
+	require.Len(t, mmu.Watchpoints(), 3)
+	require.Equal(t, "0xBFF0-0xC0FF r", mmu.Watchpoints()[0].String())
+	require.Equal(t, "0xC000 w =0x07", mmu.Watchpoints()[1].String())
+}
@@ -0,0 +1,115 @@
+// Package gbs plays GBS music rips, which hold a game's sound driver and
+// music data without the rest of the game. Only the CPU, memory and APU run,
+// the driver's init routine is called once per track and its play routine at
+// the timer or vblank rate the file asks for.
+package gbs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const headerSize = 0x70
+
+// idle is where routines return to, a JR -2 loop below the lowest load
+// address
+const idle = 0x0100
+
+// File is a parsed GBS file
+type File struct {
+	Version   byte
+	Songs     int
+	First     int // first song to play, 1 based
+	Load      uint16
+	Init      uint16
+	Play      uint16
+	SP        uint16
+	TMA       byte
+	TAC       byte
+	Title     string
+	Author    string
+	Copyright string
+	Code      []byte // loaded at Load
+}
+
+func Parse(b []byte) (*File, error) {
+	if len(b) < headerSize || string(b[:3]) != "GBS" {
+		return nil, errors.New("not a GBS file")
+	}
+	f := &File{
+		Version:   b[3],
+		Songs:     int(b[4]),
+		First:     int(b[5]),
+		Load:      binary.LittleEndian.Uint16(b[0x06:]),
+		Init:      binary.LittleEndian.Uint16(b[0x08:]),
+		Play:      binary.LittleEndian.Uint16(b[0x0A:]),
+		SP:        binary.LittleEndian.Uint16(b[0x0C:]),
+		TMA:       b[0x0E],
+		TAC:       b[0x0F],
+		Title:     text(b[0x10:0x30]),
+		Author:    text(b[0x30:0x50]),
+		Copyright: text(b[0x50:0x70]),
+		Code:      b[headerSize:],
+	}
+	if f.Version != 1 {
+		return nil, fmt.Errorf("unsupported GBS version %d", f.Version)
+	}
+	if f.Load < 0x400 || f.Load >= 0x8000 {
+		return nil, fmt.Errorf("load address 0x%04X outside 0x0400-0x7FFF", f.Load)
+	}
+	if f.First < 1 || f.First > f.Songs {
+		f.First = 1
+	}
+	return f, nil
+}
+
+func ReadFile(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// text trims the NUL padding of a header string
+func text(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+// timerDividers are the cycles per timer tick for each TAC clock select
+var timerDividers = [4]int{1024, 16, 64, 256}
+
+// Period is the number of cycles between calls to the play routine, from
+// the timer when TAC enables it and the vblank rate otherwise
+func (f *File) Period(cyclesPerFrame int) int {
+	if f.TAC&0x04 == 0 {
+		return cyclesPerFrame
+	}
+	p := timerDividers[f.TAC&3] * (256 - int(f.TMA))
+	if f.TAC&0x80 != 0 {
+		// the driver runs the CGB in double speed
+		p /= 2
+	}
+	return p
+}
+
+// rom lays the code out at the load address of a cartridge image, padded to
+// whole 16 KiB banks. RST vectors jump to the same offset from the load
+// address.
+func (f *File) rom() []byte {
+	size := (int(f.Load) + len(f.Code) + 0x3FFF) &^ 0x3FFF
+	if size < 0x8000 {
+		size = 0x8000
+	}
+	rom := make([]byte, size)
+	copy(rom[f.Load:], f.Code)
+	for v := 0; v < 0x40; v += 8 {
+		rom[v] = 0xC3 // JP a16
+		binary.LittleEndian.PutUint16(rom[v+1:], f.Load+uint16(v))
+	}
+	rom[idle], rom[idle+1] = 0x18, 0xFE // JR -2
+	return rom
+}
@@ -0,0 +1,138 @@
+package gbs
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/stretchr/testify/require"
+)
+
+// testFile builds a GBS file whose init stores the track number, switches to
+// bank 2 to store a marker and starts a tone, and whose play routine counts
+// its calls in HRAM
+func testFile(tac, tma byte) []byte {
+	const load = 0x0400
+	b := make([]byte, headerSize+0x8000)
+	copy(b, "GBS")
+	b[3] = 1 // version
+	b[4] = 3 // songs
+	b[5] = 2 // first song
+	binary.LittleEndian.PutUint16(b[0x06:], load)
+	binary.LittleEndian.PutUint16(b[0x08:], 0x0400)
+	binary.LittleEndian.PutUint16(b[0x0A:], 0x0480)
+	binary.LittleEndian.PutUint16(b[0x0C:], 0xFFFE)
+	b[0x0E] = tma
+	b[0x0F] = tac
+	copy(b[0x10:], "Test Song")
+
+	code := b[headerSize:]
+	copy(code, []byte{
+		0xEA, 0x00, 0xC0, // LD (0xC000), A
+		0x3E, 0x02, 0xEA, 0x00, 0x20, // LD A, 2; LD (0x2000), A
+		0xCD, 0x00, 0x40, // CALL 0x4000
+		0x3E, 0xF0, 0xE0, 0x17, // LD A, 0xF0; LDH (NR22), A
+		0x3E, 0x80, 0xE0, 0x16, // LD A, 0x80; LDH (NR21), A
+		0x3E, 0x87, 0xE0, 0x19, // LD A, 0x87; LDH (NR24), A
+		0xC9, // RET
+	})
+	copy(code[0x80:], []byte{
+		0xF0, 0x80, // LDH A, (0x80)
+		0x47,       // LD B, A
+		0x04,       // INC B
+		0x78,       // LD A, B
+		0xE0, 0x80, // LDH (0x80), A
+		0xC9, // RET
+	})
+	// bank 2 starts 0x8000 bytes into the image
+	copy(code[0x8000-load:], []byte{
+		0x3E, 0x42, 0xEA, 0x01, 0xC0, // LD A, 0x42; LD (0xC001), A
+		0xC9, // RET
+	})
+	return b[:headerSize+0x8000-load+6]
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(testFile(0, 0))
+	require.NoError(t, err)
+	require.Equal(t, 3, f.Songs)
+	require.Equal(t, 2, f.First)
+	require.Equal(t, uint16(0x0480), f.Play)
+	require.Equal(t, "Test Song", f.Title)
+
+	_, err = Parse([]byte("GBX"))
+	require.Error(t, err)
+}
+
+func TestPeriod(t *testing.T) {
+	f := &File{}
+	require.Equal(t, gb.CyclesPerFrame, f.Period(gb.CyclesPerFrame), "vblank")
+	f.TAC, f.TMA = 0x04, 0xC0 // 4096 Hz / 64
+	require.Equal(t, 65536, f.Period(gb.CyclesPerFrame))
+	f.TAC = 0x85
+	require.Equal(t, 16*64/2, f.Period(gb.CyclesPerFrame), "double speed")
+}
+
+func TestPlayer(t *testing.T) {
+	f, err := Parse(testFile(0x04, 0xC0))
+	require.NoError(t, err)
+	a := apu.New()
+	p := NewPlayer(f, a)
+	require.NoError(t, p.Start(3))
+
+	mmu := p.g.CPU().MMU
+	require.Equal(t, byte(2), mmu.Peek(0xC000), "track number in A")
+	require.Equal(t, byte(0x42), mmu.Peek(0xC001), "banked code")
+	require.True(t, a.Channels()[1].Enabled)
+
+	require.NoError(t, p.Run(gb.ClockSpeed))
+	require.Equal(t, byte(64), mmu.Peek(0xFF80), "play called at 64 Hz")
+
+	// switching tracks starts from a clean machine
+	require.NoError(t, p.Start(1))
+	require.Equal(t, byte(0), p.g.CPU().MMU.Peek(0xFF80))
+	require.Equal(t, 1, p.Track())
+	require.Error(t, p.Start(4))
+}
+
+func TestDriverTouchingThePPU(t *testing.T) {
+	b := testFile(0, 0)
+	copy(b[headerSize+0x80:], []byte{
+		0x3E, 0x91, 0xE0, 0x40, // LD A, 0x91; LDH (LCDC), A
+		0xEA, 0x00, 0x80, // LD (0x8000), A
+		0xEA, 0x00, 0xFE, // LD (0xFE00), A
+		0xF0, 0x40, // LDH A, (LCDC)
+		0xEA, 0x02, 0xC0, // LD (0xC002), A
+		0xC9, // RET
+	})
+	f, err := Parse(b)
+	require.NoError(t, err)
+	p := NewPlayer(f, apu.New())
+	require.NoError(t, p.Start(1))
+	require.NoError(t, p.Run(gb.CyclesPerFrame), "vram and lcd accesses are absorbed")
+	require.Equal(t, byte(0xFF), p.g.CPU().MMU.Peek(0xC002))
+	require.Equal(t, byte(0xFF), p.g.CPU().MMU.Peek(0x9800))
+}
+
+type collect []apu.Sample
+
+func (c *collect) WriteSamples(s []apu.Sample) {
+	*c = append(*c, s...)
+}
+
+func TestFade(t *testing.T) {
+	var out collect
+	f := NewFade(&out, 10, time.Second, time.Second)
+	in := make([]apu.Sample, 25)
+	for i := range in {
+		in[i] = apu.Sample{L: 1, R: -1}
+	}
+	f.WriteSamples(in)
+	require.Equal(t, apu.Sample{L: 1, R: -1}, out[9])
+	require.Equal(t, apu.Sample{L: 0.5, R: -0.5}, out[15])
+	require.Equal(t, apu.Sample{}, out[24])
+	require.True(t, f.Done())
+	require.Equal(t, float32(1), in[24].L, "input untouched")
+}
@@ -0,0 +1,172 @@
+package gbs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/shared"
+)
+
+// Player runs a GBS file's driver on a machine without a PPU
+type Player struct {
+	file  *File
+	apu   *apu.APU
+	g     *gb.Gameboy
+	track int
+	next  int // cycle the play routine is due on
+}
+
+func NewPlayer(f *File, a *apu.APU) *Player {
+	return &Player{file: f, apu: a}
+}
+
+// Songs is the number of tracks in the file
+func (p *Player) Songs() int {
+	return p.file.Songs
+}
+
+// Track is the current track, 1 based
+func (p *Player) Track() int {
+	return p.track
+}
+
+// Start resets the machine and runs the init routine for a track, 1 based
+func (p *Player) Start(track int) error {
+	if track < 1 || track > p.file.Songs {
+		return fmt.Errorf("track %d out of range 1-%d", track, p.file.Songs)
+	}
+	p.track = track
+
+	// power cycle the APU and set the registers the way the boot rom leaves
+	// them
+	p.apu.WriteByte(0xFF26, 0x00)
+	p.apu.WriteByte(0xFF26, 0x80)
+	p.apu.WriteByte(0xFF25, 0xFF)
+	p.apu.WriteByte(0xFF24, 0x77)
+
+	p.g = gb.NewGameboy(nil, p.file.rom(), noPPU{}, p.apu, gb.WithROMBanking())
+	cpu := p.g.CPU()
+	cpu.SP = p.file.SP
+	cpu.R[gb.A] = byte(track - 1)
+	if err := p.call(p.file.Init); err != nil {
+		return fmt.Errorf("init track %d: %w", track, err)
+	}
+	p.next = cpu.T
+	return nil
+}
+
+// noPPU stands in for the PPU, which a GBS driver has no use for but may still
+// touch while clearing memory. Writes to VRAM, OAM and the LCD registers are
+// absorbed and reads return 0xFF.
+type noPPU struct{}
+
+func (noPPU) ReadByte(addr uint16) (byte, error) {
+	if !ppuAddr(addr) {
+		return 0, shared.ErrUnmapped
+	}
+	return 0xFF, nil
+}
+
+func (noPPU) WriteByte(addr uint16, b byte) error {
+	if !ppuAddr(addr) {
+		return shared.ErrUnmapped
+	}
+	return nil
+}
+
+func (noPPU) Run(debugger shared.Debugger) {}
+
+func (noPPU) Step(cycles int) {}
+
+func ppuAddr(addr uint16) bool {
+	return addr >= 0x8000 && addr < 0xA000 ||
+		addr >= 0xFE00 && addr <= 0xFE9F ||
+		addr >= 0xFF40 && addr <= 0xFF4B
+}
+
+// call runs the routine at addr until it returns to the idle loop
+func (p *Player) call(addr uint16) error {
+	cpu := p.g.CPU()
+	cpu.PC = idle
+	cpu.Call(addr)
+	limit := cpu.T + gb.ClockSpeed // a routine running for a second is stuck
+	for cpu.PC != idle {
+		if cpu.T > limit {
+			return fmt.Errorf("routine at 0x%04X didn't return", addr)
+		}
+		if err := p.g.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run plays for a number of cycles, calling the play routine whenever it's
+// due and idling in between
+func (p *Player) Run(cycles int) error {
+	if p.g == nil {
+		return fmt.Errorf("no track started")
+	}
+	cpu := p.g.CPU()
+	end := cpu.T + cycles
+	for cpu.T < end {
+		if cpu.T >= p.next {
+			p.next += p.file.Period(gb.CyclesPerFrame)
+			if err := p.call(p.file.Play); err != nil {
+				return fmt.Errorf("play track %d: %w", p.track, err)
+			}
+			continue
+		}
+		if err := p.g.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cycles converts a duration of playback to cycles
+func Cycles(d time.Duration) int {
+	return int(d.Seconds() * gb.ClockSpeed)
+}
+
+// Fade passes samples through to sink at full volume for length, then fades
+// them out linearly over fade and silences everything after
+type Fade struct {
+	sink       apu.AudioSink
+	start, end int // sample numbers
+	n          int
+	buf        []apu.Sample
+}
+
+func NewFade(sink apu.AudioSink, rate int, length, fade time.Duration) *Fade {
+	start := int(length.Seconds() * float64(rate))
+	return &Fade{
+		sink:  sink,
+		start: start,
+		end:   start + int(fade.Seconds()*float64(rate)),
+	}
+}
+
+func (f *Fade) WriteSamples(s []apu.Sample) {
+	f.buf = append(f.buf[:0], s...)
+	for i := range f.buf {
+		var gain float32 = 1
+		switch {
+		case f.n >= f.end:
+			gain = 0
+		case f.n >= f.start:
+			gain = float32(f.end-f.n) / float32(f.end-f.start)
+		}
+		f.buf[i].L *= gain
+		f.buf[i].R *= gain
+		f.n++
+	}
+	f.sink.WriteSamples(f.buf)
+}
+
+// Done reports whether the fade has finished
+func (f *Fade) Done() bool {
+	return f.n >= f.end
+}
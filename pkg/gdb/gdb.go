@@ -0,0 +1,536 @@
+// Package gdb implements a GDB remote serial protocol stub so that any RSP
+// client can attach to the emulator, inspect registers and memory, set
+// breakpoints and single step.
+//
+// There is no upstream gdb architecture for the gameboy's CPU, so registers
+// are exposed in this order, each in target (little endian) byte order:
+//
+//	0-7: A F B C D E H L (8 bit)
+//	8:   SP (16 bit)
+//	9:   PC (16 bit)
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prestonp/gbc/pkg/debugger"
+	"github.com/prestonp/gbc/pkg/gb"
+)
+
+const (
+	regSP = 8
+	regPC = 9
+	nRegs = 10
+)
+
+// stop replies use signal numbers, SIGTRAP for breakpoints and steps, SIGINT
+// when the client interrupts a continue and SIGILL or SIGSEGV when the CPU
+// faults on an instruction or a memory access
+const (
+	sigInt  = 2
+	sigIll  = 4
+	sigTrap = 5
+	sigSegv = 11
+)
+
+type Server struct {
+	d *debugger.Debugger
+
+	mu     sync.Mutex
+	l      net.Listener
+	conn   net.Conn // client of the current session
+	closed bool
+}
+
+func NewServer(d *debugger.Debugger) *Server {
+	return &Server{d: d}
+}
+
+// ListenAndServe accepts clients on a TCP address, e.g. localhost:2345, one
+// session at a time. Clients can write anywhere in memory, so an address
+// without a host such as :2345 only listens on localhost.
+func (s *Server) ListenAndServe(addr string) error {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if !s.track(l, nil) {
+		return nil
+	}
+
+	log.Printf("gdb: listening on %s", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if !s.track(l, conn) {
+			conn.Close()
+			return nil
+		}
+		log.Printf("gdb: client attached from %s", conn.RemoteAddr())
+		if err := s.Serve(conn); err != nil && !s.isClosed() {
+			log.Printf("gdb: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// Close stops ListenAndServe, disconnecting the client and stopping the CPU
+// if it is running. Once ListenAndServe has returned the server no longer
+// touches the CPU.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.l != nil {
+		s.l.Close()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.d.Interrupt()
+}
+
+// track remembers what Close has to shut down, it reports false if the
+// server is already closed
+func (s *Server) track(l net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.l, s.conn = l, conn
+	return !s.closed
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// event is either a packet or an out of band interrupt read from the client
+type event struct {
+	packet    string
+	valid     bool // checksum matched
+	interrupt bool
+	nack      bool // the client asked for the last reply again
+	err       error
+}
+
+// Serve handles a single client session until it detaches or disconnects
+func (s *Server) Serve(conn io.ReadWriter) error {
+	events := make(chan event)
+	done := make(chan struct{})
+	defer close(done)
+	go readEvents(conn, events, done)
+
+	sess := &session{
+		d:      s.d,
+		w:      conn,
+		events: events,
+	}
+	return sess.run()
+}
+
+// readEvents splits the client's byte stream into packets and interrupts
+func readEvents(r io.Reader, events chan<- event, done <-chan struct{}) {
+	br := bufio.NewReader(r)
+	send := func(e event) bool {
+		select {
+		case events <- e:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			send(event{err: err})
+			return
+		}
+
+		switch b {
+		case 0x03:
+			if !send(event{interrupt: true}) {
+				return
+			}
+		case '$':
+			data, err := br.ReadString('#')
+			if err != nil {
+				send(event{err: err})
+				return
+			}
+			data = strings.TrimSuffix(data, "#")
+
+			cs := make([]byte, 2)
+			if _, err := io.ReadFull(br, cs); err != nil {
+				send(event{err: err})
+				return
+			}
+			want, err := strconv.ParseUint(string(cs), 16, 8)
+			if !send(event{packet: data, valid: err == nil && byte(want) == checksum(data)}) {
+				return
+			}
+		case '-':
+			if !send(event{nack: true}) {
+				return
+			}
+		default:
+			// acks from the client and line noise
+		}
+	}
+}
+
+type session struct {
+	d      *debugger.Debugger
+	w      io.Writer
+	events <-chan event
+	last   string // last reply, resent when the client nacks
+	err    error  // read error seen while the target was running
+}
+
+func (s *session) run() error {
+	for e := range s.events {
+		switch {
+		case e.err == io.EOF:
+			return nil
+		case e.err != nil:
+			return e.err
+		case e.interrupt:
+			// not running, report that we're stopped
+			if err := s.reply(stopReply(sigInt, nil)); err != nil {
+				return err
+			}
+			continue
+		case e.nack:
+			if err := s.reply(s.last); err != nil {
+				return err
+			}
+			continue
+		case !e.valid:
+			if _, err := io.WriteString(s.w, "-"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := io.WriteString(s.w, "+"); err != nil {
+			return err
+		}
+		if e.packet == "k" {
+			// kill has no reply
+			return nil
+		}
+
+		resp, detach := s.handle(e.packet)
+		if s.err == io.EOF {
+			return nil
+		} else if s.err != nil {
+			return s.err
+		}
+		if err := s.reply(resp); err != nil {
+			return err
+		}
+		if detach {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *session) reply(data string) error {
+	s.last = data
+	_, err := fmt.Fprintf(s.w, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+// handle executes a packet and returns the reply and whether the session ends
+func (s *session) handle(p string) (string, bool) {
+	cpu := s.d.CPU()
+	if p == "" {
+		return "", false
+	}
+
+	switch p[0] {
+	case '?':
+		return stopReply(sigTrap, nil), false
+	case 'g':
+		var b strings.Builder
+		for n := 0; n < nRegs; n++ {
+			b.WriteString(encodeReg(cpu, n))
+		}
+		return b.String(), false
+	case 'G':
+		data, err := hex.DecodeString(p[1:])
+		if err != nil || len(data) != 12 {
+			return "E01", false
+		}
+		copy(cpu.R, data[:8])
+		cpu.SP = uint16(data[8]) | uint16(data[9])<<8
+		cpu.PC = uint16(data[10]) | uint16(data[11])<<8
+		return "OK", false
+	case 'p':
+		n, err := strconv.ParseUint(p[1:], 16, 8)
+		if err != nil || n >= nRegs {
+			return "E01", false
+		}
+		return encodeReg(cpu, int(n)), false
+	case 'P':
+		parts := strings.SplitN(p[1:], "=", 2)
+		if len(parts) != 2 {
+			return "E01", false
+		}
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || n >= nRegs {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) == 0 {
+			return "E01", false
+		}
+		switch {
+		case n < regSP:
+			cpu.R[n] = data[0]
+		case len(data) < 2:
+			return "E01", false
+		case n == regSP:
+			cpu.SP = uint16(data[0]) | uint16(data[1])<<8
+		case n == regPC:
+			cpu.PC = uint16(data[0]) | uint16(data[1])<<8
+		}
+		return "OK", false
+	case 'm':
+		addr, n, ok := parseAddrLen(p[1:])
+		if !ok {
+			return "E01", false
+		}
+		buf := make([]byte, n)
+		for i := range buf {
+			buf[i] = s.d.Peek(addr + uint16(i))
+		}
+		return hex.EncodeToString(buf), false
+	case 'M':
+		parts := strings.SplitN(p[1:], ":", 2)
+		if len(parts) != 2 {
+			return "E01", false
+		}
+		addr, n, ok := parseAddrLen(parts[0])
+		if !ok {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != n {
+			return "E01", false
+		}
+		for i, b := range data {
+			if !s.d.Poke(addr+uint16(i), b) {
+				return "E01", false
+			}
+		}
+		return "OK", false
+	case 'c':
+		if !s.resume(p[1:]) {
+			return "E01", false
+		}
+		return s.cont(), false
+	case 's':
+		if !s.resume(p[1:]) {
+			return "E01", false
+		}
+		return s.stopped(s.d.Step()), false
+	case 'Z', 'z':
+		return s.breakpoint(p), false
+	case 'H':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'q':
+		switch {
+		case strings.HasPrefix(p, "qSupported"):
+			return "PacketSize=1000", false
+		case p == "qAttached":
+			return "1", false
+		case p == "qC":
+			return "QC1", false
+		case p == "qfThreadInfo":
+			return "m1", false
+		case p == "qsThreadInfo":
+			return "l", false
+		}
+	}
+
+	// empty replies tell the client the packet isn't supported
+	return "", false
+}
+
+// resume moves PC when c and s packets carry an address to resume at
+func (s *session) resume(arg string) bool {
+	if arg == "" {
+		return true
+	}
+	addr, err := strconv.ParseUint(arg, 16, 16)
+	if err != nil {
+		return false
+	}
+	s.d.CPU().PC = uint16(addr)
+	return true
+}
+
+// cont continues execution while still listening for the client's
+// interrupt. The emulator runs in its own goroutine until it stops.
+func (s *session) cont() string {
+	stopped := make(chan debugger.StopReason)
+	s.d.ClearInterrupt()
+	go func() {
+		stopped <- s.d.Continue()
+	}()
+
+	for {
+		select {
+		case reason := <-stopped:
+			return s.stopped(reason)
+		case e := <-s.events:
+			// the client may only interrupt while the target runs, a
+			// dropped connection also has to stop it
+			if e.err != nil {
+				s.err = e.err
+			}
+			if e.interrupt || e.err != nil {
+				s.d.Interrupt()
+			}
+		}
+	}
+}
+
+// breakpoint handles Z/z packets. Type 0 and 1 are software and hardware
+// breakpoints, 2-4 are write, read and access watchpoints.
+func (s *session) breakpoint(p string) string {
+	parts := strings.Split(p[1:], ",")
+	if len(parts) < 2 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	size := uint64(1)
+	if len(parts) > 2 {
+		if size, err = strconv.ParseUint(parts[2], 16, 16); err != nil || size == 0 {
+			return "E01"
+		}
+	}
+	if addr+size-1 > 0xFFFF {
+		// the range would wrap around the address space
+		return "E01"
+	}
+	insert := p[0] == 'Z'
+
+	w := gb.Watchpoint{Start: uint16(addr), End: uint16(addr + size - 1)}
+	switch parts[0] {
+	case "0", "1":
+		if insert {
+			s.d.AddBreakpoint(uint16(addr))
+		} else {
+			s.d.RemoveBreakpoint(uint16(addr))
+		}
+		return "OK"
+	case "2":
+		w.Write = true
+	case "3":
+		w.Read = true
+	case "4":
+		w.Read, w.Write = true, true
+	default:
+		return ""
+	}
+
+	if insert {
+		s.d.Watch(w)
+		return "OK"
+	}
+	for _, existing := range s.d.CPU().MMU.Watchpoints() {
+		if existing.Start == w.Start && existing.End == w.End && existing.Read == w.Read && existing.Write == w.Write {
+			s.d.Unwatch(existing.ID)
+			break
+		}
+	}
+	return "OK"
+}
+
+// stopped builds the stop reply for the reason execution stopped
+func (s *session) stopped(reason debugger.StopReason) string {
+	switch reason {
+	case debugger.StopInterrupt:
+		return stopReply(sigInt, nil)
+	case debugger.StopError:
+		if errors.Is(s.d.Err(), gb.ErrUnmapped) {
+			return stopReply(sigSegv, nil)
+		}
+		return stopReply(sigIll, nil)
+	}
+	return stopReply(sigTrap, s.d.WatchHits())
+}
+
+// stopReply reports a stop, including the address for watchpoint hits
+func stopReply(sig int, hits []gb.WatchHit) string {
+	if len(hits) == 0 {
+		return fmt.Sprintf("S%02x", sig)
+	}
+	kind := "rwatch"
+	if hits[0].Write {
+		kind = "watch"
+	}
+	return fmt.Sprintf("T%02x%s:%x;", sig, kind, hits[0].Addr)
+}
+
+func encodeReg(cpu *gb.CPU, n int) string {
+	switch n {
+	case regSP:
+		return fmt.Sprintf("%02x%02x", byte(cpu.SP), byte(cpu.SP>>8))
+	case regPC:
+		return fmt.Sprintf("%02x%02x", byte(cpu.PC), byte(cpu.PC>>8))
+	default:
+		return fmt.Sprintf("%02x", cpu.R[n])
+	}
+}
+
+func parseAddrLen(s string) (uint16, int, bool) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	n, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil || addr+n > 0x10000 {
+		return 0, 0, false
+	}
+	return uint16(addr), int(n), true
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
@@ -0,0 +1,216 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prestonp/gbc/pkg/debugger"
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// send writes a packet and returns the reply after checking the ack
+func (c *client) send(p string) string {
+	c.t.Helper()
+	_, err := fmt.Fprintf(c.conn, "$%s#%02x", p, checksum(p))
+	require.NoError(c.t, err)
+
+	ack, err := c.r.ReadByte()
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte('+'), ack, p)
+	return c.reply()
+}
+
+func (c *client) reply() string {
+	c.t.Helper()
+	start, err := c.r.ReadByte()
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte('$'), start)
+	data, err := c.r.ReadString('#')
+	require.NoError(c.t, err)
+	data = strings.TrimSuffix(data, "#")
+
+	cs := make([]byte, 2)
+	_, err = c.r.Read(cs)
+	require.NoError(c.t, err)
+	require.Equal(c.t, fmt.Sprintf("%02x", checksum(data)), string(cs))
+	return data
+}
+
+func newTestSession(t *testing.T) (*client, *gb.CPU, chan error) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0x31, 0xFE, 0xFF, // 0100 LD SP, $FFFE
+		0x3E, 0x42, // 0103 LD A, $42
+		0xEA, 0x00, 0xC0, // 0105 LD ($C000), A
+		0x04,       // 0108 INC B
+		0x18, 0xFD, // 0109 JR $0108
+	})
+	gpu := gpu.New()
+	mmu := gb.NewMMU(nil, rom, gpu, apu.New())
+	mmu.WriteByte(0xFF50, 1)
+	cpu := gb.NewCPU(mmu, gpu, false)
+	cpu.PC = 0x100
+
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(debugger.New(cpu)).Serve(server)
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}, cpu, done
+}
+
+func TestRegisters(t *testing.T) {
+	c, cpu, _ := newTestSession(t)
+
+	require.Equal(t, "PacketSize=1000", c.send("qSupported:multiprocess+"))
+	require.Equal(t, "S05", c.send("?"))
+	require.Equal(t, "", c.send("vMustReplyEmpty"))
+
+	require.Equal(t, "S05", c.send("s"))
+	require.Equal(t, "S05", c.send("s"))
+	require.Equal(t, "4200000000000000feff0501", c.send("g"))
+	require.Equal(t, "42", c.send("p0"))
+	require.Equal(t, "feff", c.send("p8"))
+	require.Equal(t, "0501", c.send("p9"))
+
+	require.Equal(t, "OK", c.send("P2=07"))
+	require.Equal(t, "OK", c.send("P9=0801"))
+	require.EqualValues(t, 0x07, cpu.R[gb.B])
+	require.EqualValues(t, 0x0108, cpu.PC)
+
+	require.Equal(t, "OK", c.send("G0100000000000000f0ff0001"))
+	require.EqualValues(t, 0x01, cpu.R[gb.A])
+	require.EqualValues(t, 0xFFF0, cpu.SP)
+	require.EqualValues(t, 0x0100, cpu.PC)
+
+	require.Equal(t, "E01", c.send("pa"))
+	require.Equal(t, "E01", c.send("G00"))
+}
+
+func TestMemory(t *testing.T) {
+	c, cpu, _ := newTestSession(t)
+
+	require.Equal(t, "31feff", c.send("m100,3"))
+	require.Equal(t, "OK", c.send("Mc010,2:abcd"))
+	require.EqualValues(t, 0xAB, cpu.MMU.ReadByte(0xC010))
+	require.EqualValues(t, 0xCD, cpu.MMU.ReadByte(0xC011))
+	require.Equal(t, "abcd", c.send("mc010,2"))
+	require.Equal(t, "E01", c.send("mffff,2"))
+	require.Equal(t, "E01", c.send("Mc010,2:ab"))
+}
+
+func TestMemoryWritesHaveNoSideEffects(t *testing.T) {
+	c, cpu, _ := newTestSession(t)
+	gb.WithUnmappedPolicy(gb.UnmappedStop)(cpu)
+
+	require.Equal(t, "OK", c.send("Z2,c020,1"))
+	require.Equal(t, "OK", c.send("Mc020,1:55"))
+	require.Empty(t, cpu.MMU.WatchHits(), "no watchpoint hit")
+
+	// rom is patched rather than switching banks
+	require.Equal(t, "OK", c.send("M2000,1:03"))
+	require.Equal(t, "03", c.send("m2000,1"))
+
+	// nothing is mapped at cart ram, the write fails without faulting
+	require.Equal(t, "E01", c.send("Ma000,1:01"))
+	require.Equal(t, "S05", c.send("s"))
+	require.EqualValues(t, 0x103, cpu.PC)
+}
+
+func TestWatchpointRange(t *testing.T) {
+	c, cpu, _ := newTestSession(t)
+
+	require.Equal(t, "E01", c.send("Z2,c000,0"), "empty range")
+	require.Equal(t, "E01", c.send("Z2,ffff,2"), "runs past 0xFFFF")
+	require.Equal(t, "OK", c.send("Z2,fffe,2"))
+	require.Equal(t, []gb.Watchpoint{{ID: 1, Start: 0xFFFE, End: 0xFFFF, Write: true}}, cpu.MMU.Watchpoints())
+}
+
+func TestBreakpointsAndContinue(t *testing.T) {
+	c, cpu, done := newTestSession(t)
+
+	require.Equal(t, "OK", c.send("Z0,108,1"))
+	require.Equal(t, "S05", c.send("c"))
+	require.EqualValues(t, 0x108, cpu.PC)
+
+	require.Equal(t, "S05", c.send("c"))
+	require.EqualValues(t, 0x108, cpu.PC)
+	require.EqualValues(t, 1, cpu.R[gb.B])
+
+	require.Equal(t, "OK", c.send("z0,108,1"))
+	require.Equal(t, "OK", c.send("Z2,c000,1"))
+	require.Equal(t, "T05watch:c000;", c.send("c100"), "resume at an address")
+	require.EqualValues(t, 0x108, cpu.PC)
+	require.Equal(t, "OK", c.send("z2,c000,1"))
+	require.Empty(t, cpu.MMU.Watchpoints())
+
+	// with nothing to stop at the target runs until the client interrupts
+	_, err := fmt.Fprintf(c.conn, "$c#%02x", checksum("c"))
+	require.NoError(t, err)
+	ack, err := c.r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('+'), ack)
+	time.Sleep(10 * time.Millisecond)
+	_, err = c.conn.Write([]byte{0x03})
+	require.NoError(t, err)
+	require.Equal(t, "S02", c.reply())
+
+	require.Equal(t, "OK", c.send("D"))
+	require.NoError(t, <-done)
+}
+
+func TestBadChecksum(t *testing.T) {
+	c, _, _ := newTestSession(t)
+
+	_, err := c.conn.Write([]byte("$g#00"))
+	require.NoError(t, err)
+	nack, err := c.r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('-'), nack)
+
+	require.Equal(t, "0001", c.send("p9"), "the session recovers after a bad packet")
+}
+
+func TestKill(t *testing.T) {
+	c, _, done := newTestSession(t)
+
+	_, err := fmt.Fprintf(c.conn, "$k#%02x", checksum("k"))
+	require.NoError(t, err)
+	ack, err := c.r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('+'), ack)
+	require.NoError(t, <-done, "kill ends the session without a reply")
+}
+
+func TestServerClose(t *testing.T) {
+	cpu := gb.NewCPU(gb.NewMMU(nil, make([]byte, 0x8000), gpu.New(), apu.New()), nil, false)
+	srv := NewServer(debugger.New(cpu))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe("localhost:0")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	srv.Close()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ListenAndServe didn't return")
+	}
+}
@@ -0,0 +1,148 @@
+// Package link connects the serial ports of two emulators over TCP. Every
+// byte the clock master shifts out is sent to the other side, which answers
+// with the byte its own port shifted out, or 0xFF when it isn't waiting on
+// the external clock.
+package link
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// Timeout is how long the master waits for the other side to answer
+const Timeout = time.Second
+
+const (
+	msgData  = 'D' // a byte shifted out by the clock master
+	msgReply = 'R' // the answer of the other side
+)
+
+// Conn is a gb.Link over a network connection
+type Conn struct {
+	conn    net.Conn
+	wmu     sync.Mutex
+	data    chan byte
+	replies chan byte
+	closed  chan struct{}
+}
+
+// Listen waits for the other emulator to connect to addr
+func Listen(addr string) (*Conn, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// Dial connects to an emulator listening on addr
+func Dial(addr string) (*Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+func New(conn net.Conn) *Conn {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// every message is a round trip, don't let them sit in a buffer
+		tcp.SetNoDelay(true)
+	}
+	c := &Conn{
+		conn:    conn,
+		data:    make(chan byte, 16),
+		replies: make(chan byte, 16),
+		closed:  make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+func (c *Conn) read() {
+	defer close(c.closed)
+	r := bufio.NewReader(c.conn)
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		// never block on a full buffer, the reader has to keep going to
+		// notice the connection closing. Nothing answers a dropped byte, so
+		// its sender times out as if the cable were unplugged.
+		switch kind {
+		case msgData:
+			select {
+			case c.data <- b:
+			default:
+			}
+		case msgReply:
+			select {
+			case c.replies <- b:
+			default:
+			}
+		}
+	}
+}
+
+func (c *Conn) send(kind, b byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write([]byte{kind, b})
+	return err
+}
+
+// Transfer sends b and waits for the answer. When both sides are clock
+// masters at once each one's data arrives while it waits for a reply, it is
+// answered with 0xFF as this side isn't on the external clock, so neither
+// waits for the timeout.
+func (c *Conn) Transfer(b byte) byte {
+	// drop answers that arrived after an earlier transfer gave up on them
+	for len(c.replies) > 0 {
+		<-c.replies
+	}
+	if err := c.send(msgData, b); err != nil {
+		return 0xFF
+	}
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case r := <-c.replies:
+			return r
+		case <-c.data:
+			c.Reply(0xFF)
+		case <-c.closed:
+			return 0xFF
+		case <-timeout:
+			return 0xFF
+		}
+	}
+}
+
+func (c *Conn) Poll() (byte, bool) {
+	select {
+	case b := <-c.data:
+		return b, true
+	default:
+		return 0, false
+	}
+}
+
+func (c *Conn) Reply(b byte) {
+	c.send(msgReply, b)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
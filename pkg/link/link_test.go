@@ -0,0 +1,135 @@
+package link
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+func newGameboy(sb, sc byte, link gb.Link) *gb.Gameboy {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0x3E, sb, 0xE0, 0x01, // LD A, sb; LDH (SB), A
+		0x3E, sc, 0xE0, 0x02, // LD A, sc; LDH (SC), A
+		0x18, 0xFE, // JR -2
+	})
+	return gb.NewGameboy(nil, rom, gpu.New(), apu.New(), gb.WithLink(link))
+}
+
+// start runs the program up to the write to SC
+func start(t *testing.T, g *gb.Gameboy) {
+	for i := 0; i < 4; i++ {
+		require.NoError(t, g.Step())
+	}
+}
+
+// finish steps until the transfer has finished and returns the received byte
+func finish(g *gb.Gameboy) (byte, error) {
+	mmu := g.CPU().MMU
+	for mmu.SC&0x80 != 0 {
+		if err := g.Step(); err != nil {
+			return 0, err
+		}
+	}
+	if mmu.IF&gb.BitSerial == 0 {
+		return 0, nil
+	}
+	return mmu.SB, nil
+}
+
+// pair connects two Conns over TCP
+func pair(t *testing.T) (client, server *Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan *Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- New(conn)
+	}()
+	client, err = Dial(l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	server = <-accepted
+	require.NotNil(t, server)
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestTransferOverTCP(t *testing.T) {
+	client, server := pair(t)
+
+	master := newGameboy(0x42, 0x81, client)
+	slave := newGameboy(0x99, 0x80, server)
+	// the slave has to be waiting on the clock before the master sends
+	start(t, slave)
+	start(t, master)
+
+	slaveGot := make(chan byte)
+	go func() {
+		b, err := finish(slave)
+		if err != nil {
+			t.Error(err)
+		}
+		slaveGot <- b
+	}()
+
+	got, err := finish(master)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x99), got)
+	require.Equal(t, byte(0x42), <-slaveGot)
+}
+
+func TestBothMasters(t *testing.T) {
+	client, server := pair(t)
+
+	a := newGameboy(0x42, 0x81, client)
+	b := newGameboy(0x99, 0x81, server)
+	start(t, a)
+	start(t, b)
+
+	begin := time.Now()
+	bGot := make(chan byte)
+	go func() {
+		got, err := finish(b)
+		if err != nil {
+			t.Error(err)
+		}
+		bGot <- got
+	}()
+
+	got, err := finish(a)
+	require.NoError(t, err)
+	require.Equal(t, byte(0xFF), got, "nothing clocks a master's data in")
+	require.Equal(t, byte(0xFF), <-bGot)
+	require.Less(t, int64(time.Since(begin)), int64(Timeout), "neither side waits for the timeout")
+}
+
+func TestReaderDoesNotBlock(t *testing.T) {
+	client, server := pair(t)
+
+	// nothing polls the server, its buffer fills up
+	for i := 0; i < 100; i++ {
+		require.NoError(t, client.send(msgData, byte(i)))
+	}
+	client.Close()
+
+	select {
+	case <-server.closed:
+	case <-time.After(time.Second):
+		t.Fatal("the reader is stuck on a full buffer")
+	}
+	b, ok := server.Poll()
+	require.True(t, ok)
+	require.Equal(t, byte(0), b, "buffered bytes are kept, later ones dropped")
+}
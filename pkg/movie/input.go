@@ -0,0 +1,136 @@
+package movie
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/prestonp/gbc/pkg/gb"
+)
+
+// Input feeds the joypad. Buttons from the frontend only reach the machine at
+// frame boundaries so that a recording sees exactly the same input on the
+// same frames as its playback, regardless of wall clock timing.
+type Input struct {
+	cpu  *gb.CPU
+	live uint32 // gb.Buttons held in the frontend
+
+	frame     uint32
+	buttons   gb.Buttons
+	recording *Movie
+	playing   *Movie
+	next      int // next event to play
+}
+
+// NewInput latches input into the cpu's joypad at every frame boundary
+func NewInput(cpu *gb.CPU) *Input {
+	in := &Input{cpu: cpu}
+	cpu.OnFrame(in.nextFrame)
+	return in
+}
+
+// SetLive sets the buttons held in the frontend. It is safe to call from any
+// goroutine, the change is applied at the next frame.
+func (in *Input) SetLive(b gb.Buttons) {
+	atomic.StoreUint32(&in.live, uint32(b))
+}
+
+// Press and Release update single buttons of the live input
+func (in *Input) Press(b gb.Buttons) {
+	for {
+		old := atomic.LoadUint32(&in.live)
+		if atomic.CompareAndSwapUint32(&in.live, old, old|uint32(b)) {
+			return
+		}
+	}
+}
+
+func (in *Input) Release(b gb.Buttons) {
+	for {
+		old := atomic.LoadUint32(&in.live)
+		if atomic.CompareAndSwapUint32(&in.live, old, old&^uint32(b)) {
+			return
+		}
+	}
+}
+
+// Record starts recording a movie. With fromState the movie embeds a save
+// state of the current machine, otherwise it is expected to start at power
+// on. Like the other methods below it must be called on the goroutine running
+// the cpu, e.g. through CPU.Do, or before the cpu starts.
+func (in *Input) Record(fromState bool) error {
+	m := &Movie{}
+	if fromState {
+		var buf bytes.Buffer
+		if err := in.cpu.SaveState(&buf); err != nil {
+			return err
+		}
+		m.State = buf.Bytes()
+	}
+	in.recording = m
+	in.frame = 0
+	in.apply()
+	return nil
+}
+
+// StopRecording returns the recorded movie. Its last event marks the frame
+// recording stopped on so that playback lasts exactly as long.
+func (in *Input) StopRecording() *Movie {
+	m := in.recording
+	in.recording = nil
+	if m != nil && m.Frames() < in.frame {
+		m.Events = append(m.Events, Event{Frame: in.frame, Buttons: in.buttons})
+	}
+	return m
+}
+
+// Play replaces live input with a movie, restoring its save state first if it
+// has one. Live input takes over again after the last event.
+func (in *Input) Play(m *Movie) error {
+	if m.State != nil {
+		if err := in.cpu.LoadState(bytes.NewReader(m.State)); err != nil {
+			return err
+		}
+	}
+	in.playing = m
+	in.next = 0
+	in.frame = 0
+	in.apply()
+	return nil
+}
+
+// Recording reports whether a movie is being recorded
+func (in *Input) Recording() bool {
+	return in.recording != nil
+}
+
+// Playing reports whether a movie still has events left to play
+func (in *Input) Playing() bool {
+	return in.playing != nil
+}
+
+func (in *Input) nextFrame() {
+	in.frame++
+	in.apply()
+}
+
+// apply latches the buttons for the current frame
+func (in *Input) apply() {
+	buttons := gb.Buttons(atomic.LoadUint32(&in.live))
+	if m := in.playing; m != nil {
+		buttons = in.buttons
+		for in.next < len(m.Events) && m.Events[in.next].Frame <= in.frame {
+			buttons = m.Events[in.next].Buttons
+			in.next++
+		}
+		if in.next >= len(m.Events) && in.frame >= m.Frames() {
+			in.playing = nil
+		}
+	}
+
+	if in.recording != nil && (len(in.recording.Events) == 0 || buttons != in.buttons) {
+		in.recording.Events = append(in.recording.Events, Event{Frame: in.frame, Buttons: buttons})
+	}
+
+	in.buttons = buttons
+	in.cpu.MMU.SetButtons(buttons)
+}
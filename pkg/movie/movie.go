@@ -0,0 +1,128 @@
+// Package movie records joypad input with the frame it changed on so a run
+// can be replayed exactly, from power on or from a save state.
+package movie
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prestonp/gbc/pkg/gb"
+)
+
+// Version is bumped whenever the movie file layout changes
+const Version = 1
+
+var magic = [4]byte{'G', 'B', 'C', 'M'}
+
+// Event is a change of the held buttons
+type Event struct {
+	Frame   uint32 // frames since the movie started
+	Buttons gb.Buttons
+}
+
+type Movie struct {
+	// State is the save state the movie starts from, nil for power on
+	State  []byte
+	Events []Event
+}
+
+// header is the fixed size part of a movie file, followed by the save state
+// and then the events
+type header struct {
+	Magic     [4]byte
+	Version   uint16
+	StateSize uint32
+	Events    uint32
+}
+
+type event struct {
+	Frame   uint32
+	Buttons uint8
+}
+
+// Write encodes the movie in its binary file format
+func (m *Movie) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	h := header{
+		Magic:     magic,
+		Version:   Version,
+		StateSize: uint32(len(m.State)),
+		Events:    uint32(len(m.Events)),
+	}
+	if err := binary.Write(bw, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	if _, err := bw.Write(m.State); err != nil {
+		return err
+	}
+	for _, e := range m.Events {
+		if err := binary.Write(bw, binary.LittleEndian, event{e.Frame, uint8(e.Buttons)}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Read decodes a movie written by Write
+func Read(r io.Reader) (*Movie, error) {
+	br := bufio.NewReader(r)
+	var h header
+	if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("read movie header: %w", err)
+	}
+	if h.Magic != magic {
+		return nil, errors.New("not a movie file")
+	}
+	if h.Version != Version {
+		return nil, fmt.Errorf("unsupported movie version %d, expected %d", h.Version, Version)
+	}
+
+	m := &Movie{}
+	if h.StateSize > 0 {
+		m.State = make([]byte, h.StateSize)
+		if _, err := io.ReadFull(br, m.State); err != nil {
+			return nil, fmt.Errorf("read movie state: %w", err)
+		}
+	}
+	for i := uint32(0); i < h.Events; i++ {
+		var e event
+		if err := binary.Read(br, binary.LittleEndian, &e); err != nil {
+			return nil, fmt.Errorf("read movie event %d: %w", i, err)
+		}
+		m.Events = append(m.Events, Event{Frame: e.Frame, Buttons: gb.Buttons(e.Buttons)})
+	}
+	return m, nil
+}
+
+// Frames is the frame of the last event, i.e. the length of the movie
+func (m *Movie) Frames() uint32 {
+	if len(m.Events) == 0 {
+		return 0
+	}
+	return m.Events[len(m.Events)-1].Frame
+}
+
+func ReadFile(path string) (*Movie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func (m *Movie) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,142 @@
+package movie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+// program folds every joypad read into C so the final state depends on which
+// buttons were held on which frames
+var program = []byte{
+	0x31, 0xFE, 0xFF, // 0100 LD SP, $FFFE
+	0x21, 0x00, 0xC0, // 0103 LD HL, $C000
+	0x3E, 0x20, // 0106 LD A, $20
+	0xE0, 0x00, // 0108 LDH ($00), A
+	0xF0, 0x00, // 010A LDH A, ($00)
+	0xA9,       // 010C XOR C
+	0x87,       // 010D ADD A, A
+	0x4F,       // 010E LD C, A
+	0x77,       // 010F LD (HL), A
+	0x18, 0xF8, // 0110 JR $010A
+}
+
+func newTestCPU() *gb.CPU {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], program)
+
+	gpu := gpu.New()
+	mmu := gb.NewMMU(nil, rom, gpu, apu.New())
+	mmu.WriteByte(0xFF50, 1)
+	cpu := gb.NewCPU(mmu, gpu, false)
+	cpu.PC = 0x100
+	return cpu
+}
+
+func runFrame(cpu *gb.CPU) {
+	frame := cpu.Frame()
+	for cpu.Frame() == frame {
+		cpu.Update()
+	}
+}
+
+func snapshot(t *testing.T, cpu *gb.CPU) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, cpu.SaveState(&buf))
+	return buf.Bytes()
+}
+
+// schedule is the live input held during each frame of the recording, it is
+// latched at the following frame boundary
+var schedule = []gb.Buttons{
+	0, 0, gb.ButtonRight, gb.ButtonRight, gb.ButtonRight | gb.ButtonUp, 0,
+	gb.ButtonLeft, gb.ButtonLeft | gb.ButtonA, 0, gb.ButtonDown, 0, 0,
+}
+
+func record(t *testing.T, cpu *gb.CPU, fromState bool) *Movie {
+	in := NewInput(cpu)
+	for i, b := range schedule {
+		in.SetLive(b)
+		if i == 0 {
+			require.NoError(t, in.Record(fromState))
+		}
+		runFrame(cpu)
+	}
+	return in.StopRecording()
+}
+
+func TestRecordAndPlay(t *testing.T) {
+	rec := newTestCPU()
+	m := record(t, rec, false)
+	require.Equal(t, []Event{
+		{Frame: 0, Buttons: 0},
+		{Frame: 3, Buttons: gb.ButtonRight},
+		{Frame: 5, Buttons: gb.ButtonRight | gb.ButtonUp},
+		{Frame: 6, Buttons: 0},
+		{Frame: 7, Buttons: gb.ButtonLeft},
+		{Frame: 8, Buttons: gb.ButtonLeft | gb.ButtonA},
+		{Frame: 9, Buttons: 0},
+		{Frame: 10, Buttons: gb.ButtonDown},
+		{Frame: 11, Buttons: 0},
+		{Frame: 12, Buttons: 0},
+	}, m.Events)
+
+	var file bytes.Buffer
+	require.NoError(t, m.Write(&file))
+	loaded, err := Read(&file)
+	require.NoError(t, err)
+	require.Equal(t, m, loaded)
+
+	play := newTestCPU()
+	in := NewInput(play)
+	in.SetLive(gb.ButtonStart) // ignored while the movie plays
+	require.NoError(t, in.Play(loaded))
+	for range schedule {
+		runFrame(play)
+	}
+	require.False(t, in.Playing())
+	require.Equal(t, snapshot(t, rec), snapshot(t, play))
+
+	// sanity check that input actually affects the outcome
+	other := newTestCPU()
+	for range schedule {
+		runFrame(other)
+	}
+	require.NotEqual(t, snapshot(t, rec), snapshot(t, other))
+}
+
+func TestRecordFromState(t *testing.T) {
+	rec := newTestCPU()
+	NewInput(rec).SetLive(gb.ButtonB)
+	runFrame(rec)
+	runFrame(rec)
+	m := record(t, rec, true)
+	require.NotEmpty(t, m.State)
+
+	var file bytes.Buffer
+	require.NoError(t, m.Write(&file))
+	loaded, err := Read(&file)
+	require.NoError(t, err)
+
+	play := newTestCPU()
+	require.NoError(t, NewInput(play).Play(loaded))
+	for range schedule {
+		runFrame(play)
+	}
+	require.Equal(t, snapshot(t, rec), snapshot(t, play))
+}
+
+func TestReadErrors(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte("nope")))
+	require.Error(t, err)
+
+	var file bytes.Buffer
+	require.NoError(t, (&Movie{Events: []Event{{Frame: 1}}}).Write(&file))
+	truncated := file.Bytes()[:file.Len()-1]
+	_, err = Read(bytes.NewReader(truncated))
+	require.Error(t, err)
+}
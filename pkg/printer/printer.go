@@ -0,0 +1,290 @@
+// Package printer emulates the Game Boy Printer on the link cable. Games
+// send it packets of tile data and print commands, every printout is saved
+// as a PNG.
+package printer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+)
+
+const (
+	cmdInit   = 0x01
+	cmdPrint  = 0x02
+	cmdData   = 0x04
+	cmdStatus = 0x0F
+
+	// the printer answers the byte after the checksum with its device ID
+	deviceID = 0x81
+
+	// bufferSize is how much tile data the printer holds, 9 bands of two
+	// tile rows
+	bandSize   = 0x280
+	bufferSize = 9 * bandSize
+)
+
+// status bits
+const (
+	StatusChecksum    = 1 << 0
+	StatusBusy        = 1 << 1
+	StatusFull        = 1 << 2
+	StatusUnprocessed = 1 << 3
+)
+
+// shades are the printer's four levels from white to black
+var shades = [4]color.Gray{{0xFF}, {0xAA}, {0x55}, {0x00}}
+
+// packet parsing stages
+const (
+	stageMagic1 = iota
+	stageMagic2
+	stageCommand
+	stageCompression
+	stageLengthLo
+	stageLengthHi
+	stageData
+	stageChecksumLo
+	stageChecksumHi
+	stageAck
+	stageStatus
+)
+
+// Printer is a gb.Link that is always clocked by the Game Boy
+type Printer struct {
+	prefix string // printouts are saved as prefix-001.png, prefix-002.png, ...
+	count  int
+
+	stage      int
+	command    byte
+	compressed bool
+	length     int
+	data       []byte
+	sum        uint16 // of the packet so far
+	checksum   uint16 // sent by the game
+
+	buffer  []byte // decompressed tile data waiting to be printed
+	status  byte
+	busy    int         // status packets left that report busy
+	printed *image.Gray // printout continued by the next print without margin
+}
+
+func New(prefix string) *Printer {
+	return &Printer{prefix: prefix}
+}
+
+// Transfer takes the next byte of a packet and returns the printer's answer
+func (p *Printer) Transfer(b byte) byte {
+	reply := byte(0x00)
+	switch p.stage {
+	case stageMagic1:
+		if b == 0x88 {
+			p.stage = stageMagic2
+		}
+		return reply
+	case stageMagic2:
+		if b != 0x33 {
+			p.stage = stageMagic1
+			return reply
+		}
+		p.sum = 0
+		p.data = p.data[:0]
+	case stageCommand:
+		p.command = b
+	case stageCompression:
+		p.compressed = b&1 != 0
+	case stageLengthLo:
+		p.length = int(b)
+	case stageLengthHi:
+		p.length |= int(b) << 8
+		if p.length == 0 {
+			p.stage = stageChecksumLo - 1
+		}
+	case stageData:
+		p.data = append(p.data, b)
+		if len(p.data) < p.length {
+			p.sum += uint16(b)
+			return reply
+		}
+	case stageChecksumLo:
+		p.checksum = uint16(b)
+	case stageChecksumHi:
+		p.checksum |= uint16(b) << 8
+		p.handle()
+	case stageAck:
+		reply = deviceID
+	case stageStatus:
+		reply = p.status
+		if p.busy > 0 {
+			p.busy--
+			if p.busy == 0 {
+				p.status &^= StatusBusy
+			}
+		}
+		p.stage = stageMagic1
+		return reply
+	}
+	if p.stage >= stageCommand && p.stage < stageChecksumLo {
+		p.sum += uint16(b)
+	}
+	p.stage++
+	return reply
+}
+
+// Poll never has anything, the printer doesn't drive the clock
+func (p *Printer) Poll() (byte, bool) { return 0, false }
+
+func (p *Printer) Reply(b byte) {}
+
+// handle runs a packet once its checksum has arrived
+func (p *Printer) handle() {
+	if p.sum != p.checksum {
+		p.status |= StatusChecksum
+		return
+	}
+	p.status &^= StatusChecksum
+
+	switch p.command {
+	case cmdInit:
+		// a new job isn't appended to a printout left waiting by the last one
+		if err := p.Close(); err != nil {
+			log.Printf("printer: %v", err)
+		}
+		p.buffer = p.buffer[:0]
+		p.status = 0
+		p.busy = 0
+	case cmdData:
+		if len(p.buffer) >= bufferSize {
+			// no room, the data is lost
+			p.status |= StatusFull
+			return
+		}
+		data := p.data
+		if p.compressed {
+			data = decompress(data)
+		}
+		if n := bufferSize - len(p.buffer); len(data) > n {
+			data = data[:n]
+		}
+		p.buffer = append(p.buffer, data...)
+		if len(p.buffer) > 0 {
+			p.status |= StatusUnprocessed
+		}
+		if len(p.buffer) >= bufferSize {
+			p.status |= StatusFull
+		}
+	case cmdPrint:
+		if len(p.data) < 4 {
+			p.status |= StatusChecksum
+			return
+		}
+		if p.data[0] > 0 {
+			// a sheet count of 0 only feeds paper
+			p.print(p.data[1], p.data[2])
+		}
+		p.buffer = p.buffer[:0]
+		p.status = p.status&^(StatusUnprocessed|StatusFull) | StatusBusy
+		p.busy = 2
+	case cmdStatus:
+	}
+}
+
+// decompress expands the run length encoding of data packets. A control
+// byte with bit 7 set repeats the next byte (c&0x7F)+2 times, otherwise c+1
+// literal bytes follow.
+func decompress(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		c := data[i]
+		i++
+		if c&0x80 != 0 {
+			if i >= len(data) {
+				break
+			}
+			for n := int(c&0x7F) + 2; n > 0; n-- {
+				out = append(out, data[i])
+			}
+			i++
+			continue
+		}
+		n := int(c) + 1
+		if i+n > len(data) {
+			n = len(data) - i
+		}
+		out = append(out, data[i:i+n]...)
+		i += n
+	}
+	return out
+}
+
+// print renders the buffered tiles, 20 to a row, with the palette. The
+// upper nibble of margins is the blank space before in tile rows and the
+// lower nibble the space after. A printout without a margin after it is
+// continued by the next print, as games do for long images.
+func (p *Printer) print(margins, palette byte) {
+	rows := len(p.buffer) / (20 * 16)
+	before, after := int(margins>>4), int(margins&0x0F)
+	img := image.NewGray(image.Rect(0, 0, 160, (before+rows+after)*8))
+	for i := range img.Pix {
+		img.Pix[i] = shades[0].Y
+	}
+
+	for tile := 0; tile < rows*20; tile++ {
+		x0, y0 := tile%20*8, (before+tile/20)*8
+		t := p.buffer[tile*16:]
+		for y := 0; y < 8; y++ {
+			lo, hi := t[y*2], t[y*2+1]
+			for x := 0; x < 8; x++ {
+				idx := (lo>>(7-x))&1 | (hi>>(7-x))&1<<1
+				img.SetGray(x0+x, y0+y, shades[palette>>(idx*2)&3])
+			}
+		}
+	}
+
+	if p.printed != nil {
+		img = appendImage(p.printed, img)
+		p.printed = nil
+	}
+	if after == 0 {
+		p.printed = img
+		return
+	}
+	if err := p.save(img); err != nil {
+		log.Printf("printer: %v", err)
+	}
+}
+
+func appendImage(top, bottom *image.Gray) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 160, top.Rect.Dy()+bottom.Rect.Dy()))
+	copy(img.Pix, top.Pix)
+	copy(img.Pix[len(top.Pix):], bottom.Pix)
+	return img
+}
+
+// save writes the next numbered PNG
+func (p *Printer) save(img image.Image) error {
+	p.count++
+	f, err := os.Create(fmt.Sprintf("%s-%03d.png", p.prefix, p.count))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close saves a printout that was still waiting to be continued, as does the
+// init command that starts the next job
+func (p *Printer) Close() error {
+	if p.printed == nil {
+		return nil
+	}
+	img := p.printed
+	p.printed = nil
+	return p.save(img)
+}
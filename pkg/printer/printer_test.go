@@ -0,0 +1,175 @@
+package printer
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// send transfers a packet and returns the device ID and status bytes
+func send(p *Printer, cmd byte, compressed bool, data []byte, corrupt bool) (id, status byte) {
+	packet := []byte{cmd, 0, byte(len(data)), byte(len(data) >> 8)}
+	if compressed {
+		packet[1] = 1
+	}
+	packet = append(packet, data...)
+	var sum uint16
+	for _, b := range packet {
+		sum += uint16(b)
+	}
+	if corrupt {
+		sum++
+	}
+	packet = append([]byte{0x88, 0x33}, packet...)
+	packet = append(packet, byte(sum), byte(sum>>8))
+	for _, b := range packet {
+		if r := p.Transfer(b); r != 0 {
+			panic("printer answered during a packet")
+		}
+	}
+	return p.Transfer(0), p.Transfer(0)
+}
+
+// band is two rows of tiles where every pixel has color index 1 in the top
+// row and 3 in the bottom one
+func band() []byte {
+	b := make([]byte, bandSize)
+	for i := 0; i < bandSize/2; i += 2 {
+		b[i] = 0xFF
+	}
+	for i := bandSize / 2; i < bandSize; i++ {
+		b[i] = 0xFF
+	}
+	return b
+}
+
+func readPNG(t *testing.T, path string) image.Image {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	return img
+}
+
+func gray(img image.Image, x, y int) uint8 {
+	r, _, _, _ := img.At(x, y).RGBA()
+	return uint8(r >> 8)
+}
+
+func TestPrint(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "print")
+	p := New(prefix)
+
+	id, status := send(p, cmdInit, false, nil, false)
+	require.Equal(t, byte(deviceID), id)
+	require.Zero(t, status)
+
+	_, status = send(p, cmdData, false, band(), false)
+	require.Equal(t, byte(StatusUnprocessed), status)
+	_, status = send(p, cmdData, false, nil, false)
+	require.Equal(t, byte(StatusUnprocessed), status, "empty data packet ends the data")
+
+	// one band before the margin, palette maps index 1 to light gray and 3
+	// to black
+	_, status = send(p, cmdPrint, false, []byte{1, 0x02, 0xE4, 0x40}, false)
+	require.Equal(t, byte(StatusBusy), status)
+	_, status = send(p, cmdStatus, false, nil, false)
+	require.Equal(t, byte(StatusBusy), status)
+	_, status = send(p, cmdStatus, false, nil, false)
+	require.Zero(t, status)
+
+	img := readPNG(t, prefix+"-001.png")
+	require.Equal(t, image.Rect(0, 0, 160, 32), img.Bounds())
+	require.Equal(t, uint8(0xAA), gray(img, 0, 0))
+	require.Equal(t, uint8(0x00), gray(img, 159, 15))
+	require.Equal(t, uint8(0xFF), gray(img, 80, 16), "margin")
+}
+
+func TestCompressedData(t *testing.T) {
+	raw := band()
+	var packed []byte
+	for i := 0; i < len(raw); {
+		// runs of up to 129 equal bytes
+		n := 1
+		for i+n < len(raw) && raw[i+n] == raw[i] && n < 129 {
+			n++
+		}
+		if n >= 2 {
+			packed = append(packed, 0x80|byte(n-2), raw[i])
+		} else {
+			packed = append(packed, 0x00, raw[i])
+		}
+		i += n
+	}
+	require.Equal(t, raw, decompress(packed))
+
+	p := New(filepath.Join(t.TempDir(), "print"))
+	send(p, cmdInit, false, nil, false)
+	send(p, cmdData, true, packed, false)
+	require.Equal(t, raw, p.buffer)
+}
+
+func TestChecksumError(t *testing.T) {
+	p := New(filepath.Join(t.TempDir(), "print"))
+	send(p, cmdInit, false, nil, false)
+	_, status := send(p, cmdData, false, band(), true)
+	require.Equal(t, byte(StatusChecksum), status)
+	require.Empty(t, p.buffer)
+
+	// the next good packet clears it
+	_, status = send(p, cmdData, false, band(), false)
+	require.Equal(t, byte(StatusUnprocessed), status)
+}
+
+func TestContinuedPrintout(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "print")
+	p := New(prefix)
+	send(p, cmdInit, false, nil, false)
+	for i := 0; i < 3; i++ {
+		send(p, cmdData, false, band(), false)
+		send(p, cmdPrint, false, []byte{1, 0x00, 0xE4, 0x40}, false)
+	}
+	_, err := os.Stat(prefix + "-001.png")
+	require.True(t, os.IsNotExist(err), "waits for a margin")
+
+	require.NoError(t, p.Close())
+	img := readPNG(t, prefix+"-001.png")
+	require.Equal(t, image.Rect(0, 0, 160, 48), img.Bounds())
+}
+
+func TestBufferFull(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "print")
+	p := New(prefix)
+	send(p, cmdInit, false, nil, false)
+	for i := 0; i < bufferSize/bandSize; i++ {
+		send(p, cmdData, false, band(), false)
+	}
+	_, status := send(p, cmdStatus, false, nil, false)
+	require.Equal(t, byte(StatusUnprocessed|StatusFull), status)
+
+	_, status = send(p, cmdData, false, band(), false)
+	require.Equal(t, byte(StatusUnprocessed|StatusFull), status)
+	require.Len(t, p.buffer, bufferSize, "data past the buffer is dropped")
+}
+
+func TestInitEndsContinuedPrintout(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "print")
+	p := New(prefix)
+	send(p, cmdInit, false, nil, false)
+	send(p, cmdData, false, band(), false)
+	send(p, cmdPrint, false, []byte{1, 0x00, 0xE4, 0x40}, false)
+
+	send(p, cmdInit, false, nil, false)
+	img := readPNG(t, prefix+"-001.png")
+	require.Equal(t, image.Rect(0, 0, 160, 16), img.Bounds())
+
+	send(p, cmdData, false, band(), false)
+	send(p, cmdPrint, false, []byte{1, 0x01, 0xE4, 0x40}, false)
+	img = readPNG(t, prefix+"-002.png")
+	require.Equal(t, image.Rect(0, 0, 160, 24), img.Bounds(), "the new job starts a new printout")
+}
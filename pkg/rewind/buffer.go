@@ -0,0 +1,90 @@
+package rewind
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// Buffer is a ring of compressed snapshots. Once full, pushing a snapshot
+// overwrites the oldest one.
+type Buffer struct {
+	buf [][]byte
+	idx int // slot the next snapshot is written to
+	n   int // number of snapshots held
+}
+
+func NewBuffer(size int) *Buffer {
+	return &Buffer{
+		buf: make([][]byte, size),
+	}
+}
+
+// Push compresses and stores a snapshot
+func (b *Buffer) Push(state []byte) error {
+	var out bytes.Buffer
+	w, err := flate.NewWriter(&out, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(state); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	b.buf[b.idx] = out.Bytes()
+	b.idx++
+	if b.idx >= len(b.buf) {
+		b.idx = 0
+	}
+	if b.n < len(b.buf) {
+		b.n++
+	}
+	return nil
+}
+
+// Pop removes and returns the most recent snapshot
+func (b *Buffer) Pop() ([]byte, bool, error) {
+	data, ok, err := b.Peek()
+	if !ok || err != nil {
+		return data, ok, err
+	}
+
+	b.idx--
+	if b.idx < 0 {
+		b.idx = len(b.buf) - 1
+	}
+	b.buf[b.idx] = nil
+	b.n--
+	return data, true, nil
+}
+
+// Peek returns the most recent snapshot without removing it
+func (b *Buffer) Peek() ([]byte, bool, error) {
+	if b.n == 0 {
+		return nil, false, nil
+	}
+
+	last := b.idx - 1
+	if last < 0 {
+		last = len(b.buf) - 1
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(b.buf[last])))
+	return data, true, err
+}
+
+// Len is the number of snapshots held
+func (b *Buffer) Len() int {
+	return b.n
+}
+
+// Size is the total compressed size of the held snapshots in bytes
+func (b *Buffer) Size() int {
+	var size int
+	for _, s := range b.buf {
+		size += len(s)
+	}
+	return size
+}
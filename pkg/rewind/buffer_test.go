@@ -0,0 +1,51 @@
+package rewind
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer(t *testing.T) {
+	t.Run("push and pop", func(t *testing.T) {
+		buf := NewBuffer(3)
+		_, ok, err := buf.Pop()
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, buf.Push([]byte("one")))
+		require.NoError(t, buf.Push([]byte("two")))
+		require.Equal(t, 2, buf.Len())
+
+		state, ok, err := buf.Peek()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("two"), state)
+
+		state, _, _ = buf.Pop()
+		require.Equal(t, []byte("two"), state)
+		state, _, _ = buf.Pop()
+		require.Equal(t, []byte("one"), state)
+		require.Zero(t, buf.Len())
+	})
+	t.Run("wrap", func(t *testing.T) {
+		buf := NewBuffer(3)
+		for _, s := range []string{"a", "b", "c", "d", "e"} {
+			require.NoError(t, buf.Push([]byte(s)))
+		}
+		require.Equal(t, 3, buf.Len())
+
+		var got []string
+		for buf.Len() > 0 {
+			state, _, err := buf.Pop()
+			require.NoError(t, err)
+			got = append(got, string(state))
+		}
+		require.Equal(t, []string{"e", "d", "c"}, got)
+	})
+	t.Run("compressed", func(t *testing.T) {
+		buf := NewBuffer(1)
+		require.NoError(t, buf.Push(make([]byte, 16*1024)))
+		require.Less(t, buf.Size(), 1024)
+	})
+}
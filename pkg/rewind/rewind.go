@@ -0,0 +1,97 @@
+// Package rewind keeps a history of machine snapshots so emulation can be
+// played backwards.
+package rewind
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+)
+
+// Machine is anything that can snapshot and restore its state, e.g. gb.CPU
+type Machine interface {
+	SaveState(w io.Writer) error
+	LoadState(r io.Reader) error
+}
+
+// Rewinder records a snapshot every interval frames while playing forward
+// and restores them newest first while rewinding
+type Rewinder struct {
+	m        Machine
+	buf      *Buffer
+	interval int
+	frames   int
+
+	rewinding int32
+}
+
+// New keeps up to size snapshots taken every interval frames, e.g. a size of
+// 1800 with an interval of 1 holds the last 30 seconds at 60 fps
+func New(m Machine, size, interval int) *Rewinder {
+	if interval < 1 {
+		interval = 1
+	}
+	return &Rewinder{
+		m:        m,
+		buf:      NewBuffer(size),
+		interval: interval,
+	}
+}
+
+// SetRewinding switches between recording and playing backwards. It is safe
+// to call from another goroutine, e.g. while a key is held in the frontend.
+func (r *Rewinder) SetRewinding(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&r.rewinding, v)
+}
+
+func (r *Rewinder) Rewinding() bool {
+	return atomic.LoadInt32(&r.rewinding) == 1
+}
+
+// Len is the number of snapshots available to rewind through
+func (r *Rewinder) Len() int {
+	return r.buf.Len()
+}
+
+// Frame must be called once per emulated frame on the goroutine running the
+// machine. While rewinding each call restores the next older snapshot, the
+// oldest one stays loaded once the history runs out.
+func (r *Rewinder) Frame() error {
+	if r.Rewinding() {
+		return r.stepBack()
+	}
+
+	r.frames++
+	if r.frames < r.interval {
+		return nil
+	}
+	r.frames = 0
+
+	var buf bytes.Buffer
+	if err := r.m.SaveState(&buf); err != nil {
+		return err
+	}
+	return r.buf.Push(buf.Bytes())
+}
+
+func (r *Rewinder) stepBack() error {
+	var (
+		state []byte
+		ok    bool
+		err   error
+	)
+	if r.buf.Len() > 1 {
+		state, ok, err = r.buf.Pop()
+	} else {
+		state, ok, err = r.buf.Peek()
+	}
+	if !ok || err != nil {
+		return err
+	}
+	r.frames = 0
+	return r.m.LoadState(bytes.NewReader(state))
+}
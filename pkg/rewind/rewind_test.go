@@ -0,0 +1,53 @@
+package rewind
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// counter is a machine whose whole state is a single byte
+type counter struct {
+	n byte
+}
+
+func (c *counter) SaveState(w io.Writer) error {
+	_, err := w.Write([]byte{c.n})
+	return err
+}
+
+func (c *counter) LoadState(r io.Reader) error {
+	b := make([]byte, 1)
+	_, err := io.ReadFull(r, b)
+	c.n = b[0]
+	return err
+}
+
+func TestRewinder(t *testing.T) {
+	m := &counter{}
+	r := New(m, 4, 2)
+
+	// play forward, snapshotting every other frame
+	for i := 0; i < 10; i++ {
+		m.n++
+		require.NoError(t, r.Frame())
+	}
+	require.Equal(t, 4, r.Len(), "only the newest snapshots are kept")
+
+	r.SetRewinding(true)
+	require.True(t, r.Rewinding())
+
+	var got []byte
+	for i := 0; i < 5; i++ {
+		require.NoError(t, r.Frame())
+		got = append(got, m.n)
+	}
+	require.Equal(t, []byte{10, 8, 6, 4, 4}, got, "holds the oldest snapshot once history runs out")
+
+	r.SetRewinding(false)
+	m.n = 20
+	require.NoError(t, r.Frame())
+	require.NoError(t, r.Frame())
+	require.Equal(t, 2, r.Len())
+}
@@ -1,5 +1,10 @@
 package shared
 
+import "errors"
+
 type Debugger interface {
 	String() string
 }
+
+// ErrUnmapped is returned by modules for addresses they don't implement
+var ErrUnmapped = errors.New("unmapped address")
@@ -0,0 +1,130 @@
+// Package wav records the mixed APU output to 16-bit stereo PCM WAV files.
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/prestonp/gbc/pkg/gb/apu"
+)
+
+// headerSize is the size of the RIFF, fmt and data chunk headers
+const headerSize = 44
+
+// header is the canonical 44 byte WAV header for PCM data
+type header struct {
+	RIFF          [4]byte
+	RIFFSize      uint32
+	WAVE          [4]byte
+	Fmt           [4]byte
+	FmtSize       uint32
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte
+	DataSize      uint32
+}
+
+func newHeader(rate int, samples uint32) header {
+	return header{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		RIFFSize:      headerSize - 8 + samples*4,
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1, // PCM
+		Channels:      2,
+		SampleRate:    uint32(rate),
+		ByteRate:      uint32(rate) * 4,
+		BlockAlign:    4,
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      samples * 4,
+	}
+}
+
+// Writer is an apu.AudioSink that encodes samples as they're produced. The
+// sizes in the header are only filled in by Close.
+type Writer struct {
+	w       io.WriteSeeker
+	bw      *bufio.Writer
+	rate    int
+	samples uint32
+	err     error // first write error, reported by Close
+}
+
+// NewWriter writes a WAV header for the sample rate to w
+func NewWriter(w io.WriteSeeker, rate int) (*Writer, error) {
+	wr := &Writer{w: w, bw: bufio.NewWriter(w), rate: rate}
+	if err := binary.Write(wr.bw, binary.LittleEndian, newHeader(rate, 0)); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// Create records to a new file at path
+func Create(path string, rate int) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, rate)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) WriteSamples(s []apu.Sample) {
+	if w.err != nil {
+		return
+	}
+	buf := make([]int16, 0, len(s)*2)
+	for _, v := range s {
+		buf = append(buf, pcm(v.L), pcm(v.R))
+	}
+	if w.err = binary.Write(w.bw, binary.LittleEndian, buf); w.err == nil {
+		w.samples += uint32(len(s))
+	}
+}
+
+// pcm clamps a sample to [-1, 1] and scales it to 16 bits
+func pcm(v float32) int16 {
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	return int16(v * 32767)
+}
+
+// Samples is the number of stereo samples written so far
+func (w *Writer) Samples() uint32 {
+	return w.samples
+}
+
+// Close fills in the header sizes and closes the destination if it is an
+// io.Closer
+func (w *Writer) Close() error {
+	err := w.err
+	if ferr := w.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if err == nil {
+		if _, err = w.w.Seek(0, io.SeekStart); err == nil {
+			err = binary.Write(w.w, binary.LittleEndian, newHeader(w.rate, w.samples))
+		}
+	}
+	if c, ok := w.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package wav
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prestonp/gbc/pkg/gb"
+	"github.com/prestonp/gbc/pkg/gb/apu"
+	"github.com/prestonp/gbc/pkg/gb/gpu"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	w, err := Create(path, 44100)
+	require.NoError(t, err)
+	w.WriteSamples([]apu.Sample{{L: 1, R: -1}, {L: 2, R: 0.5}})
+	require.Equal(t, uint32(2), w.Samples())
+	require.NoError(t, w.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, b, headerSize+8)
+	require.Equal(t, "RIFF", string(b[0:4]))
+	require.Equal(t, uint32(len(b)-8), binary.LittleEndian.Uint32(b[4:]))
+	require.Equal(t, "WAVE", string(b[8:12]))
+	require.Equal(t, uint32(44100), binary.LittleEndian.Uint32(b[24:]))
+	require.Equal(t, "data", string(b[36:40]))
+	require.Equal(t, uint32(8), binary.LittleEndian.Uint32(b[40:]))
+
+	var pcm [4]int16
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(b[headerSize+i*2:]))
+	}
+	require.Equal(t, [4]int16{32767, -32767, 32767, 16383}, pcm, "clamped")
+}
+
+// record runs a program that plays a tone for a number of frames and
+// returns the hash of the recording
+func record(t *testing.T, frames int) [32]byte {
+	program := []byte{
+		0x3E, 0x77, 0xE0, 0x24, // LD A, 0x77; LDH (NR50), A
+		0x3E, 0xFF, 0xE0, 0x25, // LD A, 0xFF; LDH (NR51), A
+		0x3E, 0xF3, 0xE0, 0x17, // LD A, 0xF3; LDH (NR22), A
+		0x3E, 0x80, 0xE0, 0x16, // LD A, 0x80; LDH (NR21), A
+		0x3E, 0x87, 0xE0, 0x19, // LD A, 0x87; LDH (NR24), A
+		0x18, 0xFE, // JR -2
+	}
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], program)
+
+	path := filepath.Join(t.TempDir(), "out.wav")
+	a := apu.New(apu.WithSampleRate(44100))
+	w, err := Create(path, a.SampleRate())
+	require.NoError(t, err)
+	a.SetSink(w)
+
+	g := gb.NewGameboy(nil, rom, gpu.New(), a)
+	for g.CPU().Frame() < frames {
+		require.NoError(t, g.Step())
+	}
+	a.Flush()
+	require.NoError(t, w.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Greater(t, len(b), headerSize+frames*700*4)
+	return sha256.Sum256(b)
+}
+
+// recordingHash is the hash of one second of the test tone. It changes
+// whenever the audio output does, update it only after listening to the new
+// output.
+const recordingHash = "99d4cbf29565cd94ded76df9510a6c00c3f113f77cd02bfd66f2132b1e362cd6"
+
+func TestRecordingIsDeterministic(t *testing.T) {
+	h := record(t, 60)
+	require.Equal(t, h, record(t, 60))
+	require.Equal(t, recordingHash, hex.EncodeToString(h[:]))
+}